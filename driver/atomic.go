@@ -0,0 +1,174 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	lockedFile "github.com/rogpeppe/go-internal/lockedfile"
+)
+
+// openLocked opens and locks path. Since writes replace the file by renaming a new one over it, the lock may be
+// granted on a file that is no longer at path; in that case the file is reopened until the locked file is the current one.
+func openLocked(path string, perm os.FileMode) (*lockedFile.File, error) {
+	for {
+		f, err := lockedFile.OpenFile(path, os.O_RDWR|os.O_CREATE, perm)
+		if err != nil {
+			return nil, err
+		}
+
+		locked, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		current, err := os.Stat(path)
+		if err == nil && os.SameFile(locked, current) {
+			return f, nil
+		}
+		f.Close()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+}
+
+// writeFile atomically replaces the content of the locked config file with data.
+// The data is written to a locked temp file in the same directory, synced, given the mode and ownership of the
+// original file and renamed over it; then the directory is synced. The locked temp file becomes the new lockedFile,
+// so the lock is held on the file that is at path.
+// If the ownership of the original file cannot be preserved, writing fails unless inPlaceFallback is set; then the
+// file is rewritten in place. Where an open file cannot be replaced (Windows), the file is always rewritten in place.
+// In dry-run mode data only becomes the would-be content.
+func (m *Config) writeFile(data []byte) error {
+	if m.dryRun != nil {
+		m.dryRun.data = data
+		m.dryRun.loaded = true
+		return nil
+	}
+
+	if !canReplaceOpenFile {
+		return m.writeFileInPlace(data)
+	}
+
+	info, err := m.lockedFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(m.path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(m.path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	f, err := lockedFile.OpenFile(tmpPath, os.O_RDWR, m.perm)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := replaceFile(f, tmpPath, m.path, info, data); err != nil {
+		f.Close()
+		os.Remove(tmpPath)
+		if errors.Is(err, errOwnership) && m.inPlaceFallback {
+			return m.writeFileInPlace(data)
+		}
+		return errors.Wrapf(err, "replace %s", m.path)
+	}
+
+	if err := SyncDir(dir); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "sync %s", dir)
+	}
+
+	old := m.lockedFile
+	m.lockedFile = f
+	return old.Close()
+}
+
+var errOwnership = errors.New("cannot preserve file ownership")
+
+// WriteFileAtomic writes data to a temp file next to path, syncs it and renames it over path, so path has either its
+// old or its new content even after a crash.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath, err := WriteTempFile(path, data, perm)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return SyncDir(filepath.Dir(path))
+}
+
+// WriteTempFile writes data to a synced temp file with mode perm next to path and returns the path of the temp file,
+// ready to be renamed over path.
+func WriteTempFile(path string, data []byte, perm os.FileMode) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+
+	err = func() error {
+		defer tmp.Close()
+		if _, err := tmp.Write(data); err != nil {
+			return err
+		}
+		if err := tmp.Chmod(perm); err != nil {
+			return err
+		}
+		return tmp.Sync()
+	}()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// replaceFile writes data to the temp file f, copies mode and ownership from info and renames it to path.
+func replaceFile(f *lockedFile.File, tmpPath, path string, info os.FileInfo, data []byte) error {
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := chown(f, info); err != nil {
+		return errors.Wrap(errOwnership, err.Error())
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// writeFileInPlace truncates the locked file and writes data into it.
+func (m *Config) writeFileInPlace(data []byte) error {
+	if err := m.lockedFile.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := m.lockedFile.Seek(0, 0); err != nil {
+		return err
+	}
+
+	if _, err := m.lockedFile.Write(data); err != nil {
+		return err
+	}
+
+	return m.lockedFile.Sync()
+}
@@ -0,0 +1,43 @@
+//go:build !windows
+// +build !windows
+
+package config
+
+import (
+	"os"
+	"syscall"
+
+	lockedFile "github.com/rogpeppe/go-internal/lockedfile"
+)
+
+// canReplaceOpenFile reports whether a file that is open, like the locked config, can be renamed over.
+const canReplaceOpenFile = true
+
+// chown gives f the owner and group from info.
+func chown(f *lockedFile.File, info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	tmpInfo, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if tmp, ok := tmpInfo.Sys().(*syscall.Stat_t); ok && tmp.Uid == st.Uid && tmp.Gid == st.Gid {
+		return nil
+	}
+
+	return f.Chown(int(st.Uid), int(st.Gid))
+}
+
+// SyncDir flushes the directory entry changes of dir, such as a rename, to disk.
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,24 @@
+//go:build windows
+// +build windows
+
+package config
+
+import (
+	"os"
+
+	lockedFile "github.com/rogpeppe/go-internal/lockedfile"
+)
+
+// canReplaceOpenFile is false on Windows, where a file that is open, like the locked config, cannot be renamed over;
+// the config is rewritten in place instead.
+const canReplaceOpenFile = false
+
+// chown is a no-op on Windows, where new files inherit the directory ACL.
+func chown(_ *lockedFile.File, _ os.FileInfo) error {
+	return nil
+}
+
+// SyncDir is a no-op on Windows, which does not support syncing directories.
+func SyncDir(_ string) error {
+	return nil
+}
@@ -0,0 +1,276 @@
+package config
+
+import (
+	"fmt"
+	nurl "net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c2pc/config-migrate/internal/url"
+	"github.com/pkg/errors"
+)
+
+// backupTimeFormat is the time in backup names, precise enough not to overwrite an older backup of the same version.
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// Backup is a copy of the config file made before a migration run.
+type Backup struct {
+	// Path — the path to the backup file.
+	Path string
+
+	// Version — the version of the config in the backup; 0 if it had no version.
+	Version int
+
+	// Time — when the backup was made.
+	Time time.Time
+}
+
+// Backups returns the backups of the config file, oldest first.
+// Backups named "config.yaml.vN.backup" by older releases are included with the time they were last modified.
+func (m *Config) Backups() ([]Backup, error) {
+	entries, err := os.ReadDir(m.backupDirectory())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []Backup
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), filepath.Base(m.path)+".v") {
+			continue
+		}
+		b, ok := parseBackupName(filepath.Join(m.backupDirectory(), entry.Name()))
+		if !ok {
+			continue
+		}
+		if b.Time.IsZero() {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			b.Time = info.ModTime().UTC()
+		}
+		backups = append(backups, b)
+	}
+
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].Time.Before(backups[j].Time)
+	})
+	return backups, nil
+}
+
+// Restore atomically replaces the config file with a backup under the file lock and stores the version of the backup
+// as not dirty. target is a version, restoring the newest backup of that version, or the path to a backup file;
+// empty restores the newest backup. It returns the restored backup.
+func (m *Config) Restore(target string) (Backup, error) {
+	b, err := m.findBackup(target)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	data, err := os.ReadFile(b.Path)
+	if err != nil {
+		return Backup{}, err
+	}
+
+	if err := m.Lock(); err != nil {
+		return Backup{}, err
+	}
+	defer m.Unlock()
+
+	if err := m.writeFile(data); err != nil {
+		return Backup{}, err
+	}
+
+	if b.Version == 0 {
+		return b, m.dropVersion()
+	}
+
+	version, dirty, err := m.readVersion(data)
+	if err != nil {
+		return Backup{}, err
+	}
+	if version == b.Version && !dirty {
+		return b, nil
+	}
+	return b, m.setVersion(b.Version, false)
+}
+
+// findBackup returns the backup Restore should restore for target.
+func (m *Config) findBackup(target string) (Backup, error) {
+	version, err := strconv.Atoi(target)
+	if target != "" && err != nil {
+		b, ok := parseBackupName(target)
+		if !ok {
+			return Backup{}, errors.Errorf("%s is not a backup file", target)
+		}
+		if b.Path, err = url.ParseURL(b.Path); err != nil {
+			return Backup{}, err
+		}
+		return b, nil
+	}
+
+	backups, err := m.Backups()
+	if err != nil {
+		return Backup{}, err
+	}
+	for i := len(backups) - 1; i >= 0; i-- {
+		if target == "" || backups[i].Version == version {
+			return backups[i], nil
+		}
+	}
+
+	if target == "" {
+		return Backup{}, errors.Errorf("no backups of %s found in %s", m.path, m.backupDirectory())
+	}
+	return Backup{}, errors.Errorf("no backup of %s at version %d found in %s", m.path, version, m.backupDirectory())
+}
+
+// backup saves the current config file once per Lock session if BackupBeforeMigrate is set
+// and removes the backups that are no longer retained.
+func (m *Config) backup() error {
+	if !m.backupBeforeMigrate || m.backedUpThisSession || m.dryRun != nil {
+		return nil
+	}
+
+	fileData, err := m.readFile()
+	if err != nil {
+		return err
+	}
+
+	version, _, _ := m.readVersion(fileData)
+	if version < 0 {
+		version = 0
+	}
+
+	dir := m.backupDirectory()
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return errors.Wrapf(err, "backup before migrate: create %s", dir)
+	}
+
+	name := fmt.Sprintf("%s.v%d.%s.backup", filepath.Base(m.path), version, time.Now().UTC().Format(backupTimeFormat))
+	backupPath := filepath.Join(dir, name)
+	if err := WriteFileAtomic(backupPath, fileData, m.perm); err != nil {
+		return errors.Wrapf(err, "backup before migrate: write %s", backupPath)
+	}
+	m.backedUpThisSession = true
+
+	return m.pruneBackups()
+}
+
+// pruneBackups removes the backups beyond BackupKeep and those older than BackupMaxAge.
+func (m *Config) pruneBackups() error {
+	if m.backupKeep <= 0 && m.backupMaxAge <= 0 {
+		return nil
+	}
+
+	backups, err := m.Backups()
+	if err != nil {
+		return err
+	}
+
+	for i, b := range backups {
+		tooMany := m.backupKeep > 0 && i < len(backups)-m.backupKeep
+		tooOld := m.backupMaxAge > 0 && time.Since(b.Time) > m.backupMaxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(b.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupDirectory returns the directory backups are written to.
+func (m *Config) backupDirectory() string {
+	if m.backupDir != "" {
+		return m.backupDir
+	}
+	return filepath.Dir(m.path)
+}
+
+// setBackupDir configures the directory of the backups.
+func (m *Config) setBackupDir(backupDir string) error {
+	m.backupDir = ""
+	if backupDir == "" {
+		return nil
+	}
+
+	path, err := url.ParseURL(backupDir)
+	if err != nil {
+		return err
+	}
+	m.backupDir = path
+	return nil
+}
+
+// setBackupQuery configures backups from the x-backup, x-backup-dir, x-backup-keep and x-backup-max-age query parameters.
+func (m *Config) setBackupQuery(query nurl.Values) error {
+	if query.Has("x-backup") {
+		backup, err := strconv.ParseBool(query.Get("x-backup"))
+		if err != nil {
+			return errors.Wrap(err, "x-backup")
+		}
+		m.backupBeforeMigrate = backup
+	}
+
+	if query.Has("x-backup-dir") {
+		if err := m.setBackupDir(query.Get("x-backup-dir")); err != nil {
+			return err
+		}
+	}
+
+	if query.Has("x-backup-keep") {
+		keep, err := strconv.Atoi(query.Get("x-backup-keep"))
+		if err != nil {
+			return errors.Wrap(err, "x-backup-keep")
+		}
+		m.backupKeep = keep
+	}
+
+	if query.Has("x-backup-max-age") {
+		maxAge, err := time.ParseDuration(query.Get("x-backup-max-age"))
+		if err != nil {
+			return errors.Wrap(err, "x-backup-max-age")
+		}
+		m.backupMaxAge = maxAge
+	}
+
+	return nil
+}
+
+// parseBackupName parses a backup path like "config.yaml.v2.20260102T150405.000000000Z.backup"
+// or "config.yaml.v2.backup". The time of the latter is zero.
+func parseBackupName(path string) (Backup, bool) {
+	name := filepath.Base(path)
+	if !strings.HasSuffix(name, ".backup") {
+		return Backup{}, false
+	}
+	name = strings.TrimSuffix(name, ".backup")
+
+	b := Backup{Path: path}
+	if i := len(name) - len(backupTimeFormat) - 1; i > 0 && name[i] == '.' {
+		if t, err := time.Parse(backupTimeFormat, name[i+1:]); err == nil {
+			b.Time = t
+			name = name[:i]
+		}
+	}
+
+	i := strings.LastIndex(name, ".v")
+	if i < 0 {
+		return Backup{}, false
+	}
+	version, err := strconv.Atoi(name[i+2:])
+	if err != nil || version < 0 {
+		return Backup{}, false
+	}
+	b.Version = version
+	return b, true
+}
@@ -5,55 +5,125 @@ import (
 	"io/fs"
 	"os"
 	"strconv"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/c2pc/config-migrate/internal/url"
 	"github.com/c2pc/config-migrate/merger"
 	"github.com/golang-migrate/migrate/v4/database"
 	"github.com/pkg/errors"
 	lockedFile "github.com/rogpeppe/go-internal/lockedfile"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 )
 
 // Config represents the core struct used to manage config-based migrations.
 // It contains a driver for reading/writing config data and a locked file to prevent concurrent access.
 type Config struct {
-	driver                  Driver           // Custom config driver implementing (Un)Marshal and Version logic
-	lockedFile              *lockedFile.File // File handle with locking to avoid race conditions
-	mu                      sync.Mutex       // Mutex to synchronize file access
-	path                    string           // Path to the configuration file
-	perm                    fs.FileMode      // File permissions
-	unableToReplaceComments bool             // True if some comments could be replaced
-	onlyOneVersion          bool             // True if you want to maintain only one version of the config and don't want to create multiple files
-	backupBeforeMigrate     bool             // If true, backup config once per migration run (before first Run in this session)
-	backedUpThisSession     bool             // Whether we already wrote a backup in this Lock session
+	driver                  Driver                       // Custom config driver implementing (Un)Marshal and Version logic
+	baseDriver              Driver                       // Driver of New; every Open starts from it
+	settings                Settings                     // Settings of New; every Open starts from them
+	lockedFile              *lockedFile.File             // File handle with locking to avoid race conditions
+	mu                      sync.Mutex                   // Mutex to synchronize file access
+	path                    string                       // Path to the configuration file
+	perm                    fs.FileMode                  // File permissions
+	unableToReplaceComments bool                         // True if some comments could be replaced
+	onlyOneVersion          bool                         // True if you want to maintain only one version of the config and don't want to create multiple files
+	backupBeforeMigrate     bool                         // If true, backup config once per migration run (before first Run in this session)
+	backedUpThisSession     bool                         // Whether we already wrote a backup in this Lock session
+	backupDir               string                       // Directory of the backups; empty for the directory of the config
+	backupKeep              int                          // Number of backups to keep; 0 to keep all
+	backupMaxAge            time.Duration                // Age after which backups are removed; 0 to keep them
+	preserveFormatting      bool                         // If true, write merged data onto the existing document when the driver is a Patcher
+	inPlaceFallback         bool                         // If true, rewrite the file in place when its ownership cannot be preserved
+	versionFile             string                       // Sidecar file storing version and force; empty to store them in the config
+	versionKey              string                       // Key path of the version in the config file
+	forceKey                string                       // Key path of the dirty flag in the config file
+	dryRun                  *dryRunState                 // Would-be state when nothing must be written; nil otherwise
+	onDiff                  func(Diff)                   // Called with the changes of every migration applied by Run; may be nil
+	onExplain               func(Explanation)            // Called with the origin of every value after each migration applied by Run; may be nil
+	target                  int                          // Version passed to the last SetVersion, the target of the next Run
+	historyFile             string                       // JSONL file applied migrations are appended to; empty to keep no history
+	step                    *migrationStep               // Migration in progress between a dirty and a clean SetVersion; nil otherwise
+	schema                  *jsonschema.Schema           // JSON Schema the merged config is validated against; nil to skip validation
+	arrayMergeMode          merger.ArrayMode             // Default mode arrays are merged in
+	strictMerge             bool                         // If true, directives that cannot be applied fail the migration
+	onMergeWarning          func(merger.DirectiveErrors) // Called with directives that cannot be applied unless strictMerge; may be nil
 }
 
 // New returns a new instance of the config driver using the given settings.
 func New(driver Driver, cfg Settings) database.Driver {
+	m := &Config{
+		baseDriver: driver,
+		settings:   cfg,
+	}
+
+	if err := m.applySettings(); err != nil {
+		panic(err)
+	}
+
+	return m
+}
+
+// applySettings configures the instance from its settings, undoing the query parameters of an earlier Open.
+func (m *Config) applySettings() error {
+	cfg := m.settings
+	m.driver = m.baseDriver
+
 	path, err := url.ParseURL(cfg.Path)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	m.path = path
 
-	perm := DefaultPerm
+	m.perm = DefaultPerm
 	if cfg.Perm != 0 {
-		perm = cfg.Perm
+		m.perm = cfg.Perm
 	}
 
-	m := &Config{
-		driver:                  driver,
-		path:                    path,
-		perm:                    perm,
-		unableToReplaceComments: cfg.UnableToReplaceComments,
-		onlyOneVersion:          cfg.OnlyOneVersion,
-		backupBeforeMigrate:     cfg.BackupBeforeMigrate,
+	m.unableToReplaceComments = cfg.UnableToReplaceComments
+	m.onlyOneVersion = cfg.OnlyOneVersion
+	m.backupBeforeMigrate = cfg.BackupBeforeMigrate
+	m.backupKeep = cfg.BackupKeep
+	m.backupMaxAge = cfg.BackupMaxAge
+	m.preserveFormatting = cfg.PreserveFormatting
+	m.inPlaceFallback = cfg.InPlaceFallback
+	m.onDiff = cfg.OnDiff
+	m.onExplain = cfg.OnExplain
+	m.strictMerge = cfg.StrictMerge
+	m.onMergeWarning = cfg.OnMergeWarning
+
+	if err := m.setVersionStorage(cfg.VersionFile, cfg.VersionKey, cfg.ForceKey); err != nil {
+		return err
 	}
 
-	return m
+	if err := m.setHistoryFile(cfg.HistoryFile); err != nil {
+		return err
+	}
+
+	if err := m.setBackupDir(cfg.BackupDir); err != nil {
+		return err
+	}
+
+	if m.schema, err = compileSchema(cfg.Schema, cfg.SchemaFile); err != nil {
+		return err
+	}
+
+	if m.arrayMergeMode, err = merger.ParseArrayMode(string(cfg.ArrayMergeMode)); err != nil {
+		return err
+	}
+
+	m.setDryRun(cfg.DryRun)
+
+	return nil
 }
 
-// Open sets the file path from a URL and returns the current instance.
+// Open sets the file path from a URL and returns the current instance. Every Open starts from the settings of New,
+// so query parameters of an earlier URL do not carry over.
+// The version storage can be set with the x-version-file, x-version-key and x-force-key query parameters,
+// the history file with x-history-file, the JSON Schema with x-schema-file, backups with x-backup=true, x-backup-dir, x-backup-keep and x-backup-max-age,
+// the default array merge mode with x-array-merge-mode, strict merging with x-strict-merge=true, dry-run mode with x-dry-run=true,
+// rewriting in place when the ownership cannot be preserved with x-in-place-fallback=true
+// and writing onto the existing document with x-preserve-formatting=true. A Configurable driver reads its own parameters.
 func (m *Config) Open(filePath string) (database.Driver, error) {
 
 	path, err := url.ParseURL(filePath)
@@ -61,12 +131,117 @@ func (m *Config) Open(filePath string) (database.Driver, error) {
 		return nil, err
 	}
 
+	query, err := url.ParseQuery(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.applySettings(); err != nil {
+		return nil, err
+	}
+
+	if query.Has("x-version-file") || query.Has("x-version-key") || query.Has("x-force-key") {
+		if err := m.setVersionStorage(query.Get("x-version-file"), query.Get("x-version-key"), query.Get("x-force-key")); err != nil {
+			return nil, err
+		}
+	}
+
+	if query.Has("x-history-file") {
+		if err := m.setHistoryFile(query.Get("x-history-file")); err != nil {
+			return nil, err
+		}
+	}
+
+	if query.Has("x-schema-file") {
+		if m.schema, err = compileSchema(nil, query.Get("x-schema-file")); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.setBackupQuery(query); err != nil {
+		return nil, err
+	}
+
+	if query.Has("x-array-merge-mode") {
+		if m.arrayMergeMode, err = merger.ParseArrayMode(query.Get("x-array-merge-mode")); err != nil {
+			return nil, errors.Wrap(err, "x-array-merge-mode")
+		}
+	}
+
+	if query.Has("x-strict-merge") {
+		if m.strictMerge, err = strconv.ParseBool(query.Get("x-strict-merge")); err != nil {
+			return nil, errors.Wrap(err, "x-strict-merge")
+		}
+	}
+
+	if query.Has("x-in-place-fallback") {
+		if m.inPlaceFallback, err = strconv.ParseBool(query.Get("x-in-place-fallback")); err != nil {
+			return nil, errors.Wrap(err, "x-in-place-fallback")
+		}
+	}
+
+	if query.Has("x-preserve-formatting") {
+		if m.preserveFormatting, err = strconv.ParseBool(query.Get("x-preserve-formatting")); err != nil {
+			return nil, errors.Wrap(err, "x-preserve-formatting")
+		}
+	}
+
+	if configurable, ok := m.driver.(Configurable); ok {
+		if m.driver, err = configurable.Configure(query); err != nil {
+			return nil, err
+		}
+	}
+
+	if query.Has("x-dry-run") {
+		dryRun, err := strconv.ParseBool(query.Get("x-dry-run"))
+		if err != nil {
+			return nil, errors.Wrap(err, "x-dry-run")
+		}
+		m.setDryRun(dryRun)
+	}
+
 	m.path = path
 	return m, nil
 }
 
+// setDryRun enables or disables dry-run mode, discarding any would-be state.
+func (m *Config) setDryRun(dryRun bool) {
+	m.dryRun = nil
+	if dryRun {
+		m.dryRun = &dryRunState{}
+	}
+}
+
+// setVersionStorage configures where version and force are stored.
+func (m *Config) setVersionStorage(versionFile, versionKey, forceKey string) error {
+	m.versionFile = ""
+	if versionFile != "" {
+		path, err := url.ParseURL(versionFile)
+		if err != nil {
+			return err
+		}
+		m.versionFile = path
+	}
+
+	m.versionKey = DefaultVersionKey
+	if versionKey != "" {
+		m.versionKey = versionKey
+	}
+
+	m.forceKey = forceKey
+	if m.forceKey == "" {
+		m.forceKey = forceKeyFor(m.versionKey)
+	}
+
+	return nil
+}
+
 // Close closes the locked file if open.
 func (m *Config) Close() error {
+	if m.lockedFile == nil {
+		return nil
+	}
+
 	if err := m.lockedFile.Close(); err != nil {
 		return err
 	}
@@ -76,13 +251,18 @@ func (m *Config) Close() error {
 
 // Lock opens the file with locking and stores the handle for later operations.
 func (m *Config) Lock() error {
-	f, err := lockedFile.OpenFile(m.path, os.O_RDWR|os.O_CREATE, m.perm)
-	if err != nil {
-		return err
+	var f *lockedFile.File
+	// A dry run must not create a missing config file
+	if _, err := os.Stat(m.path); m.dryRun == nil || !os.IsNotExist(err) {
+		f, err = openLocked(m.path, m.perm)
+		if err != nil {
+			return err
+		}
 	}
 	m.mu.Lock()
 
 	m.lockedFile = f
+	m.backedUpThisSession = false
 
 	return nil
 }
@@ -101,18 +281,18 @@ func (m *Config) Run(migration io.Reader) error {
 		return err
 	}
 
+	if m.step != nil {
+		m.step.migrationChecksum = checksum(migrData)
+	}
+
 	// Unmarshal migration data into a map
 	migrMap := map[string]interface{}{}
 	if err := m.driver.Unmarshal(migrData, &migrMap); err != nil {
 		return errors.Wrapf(err, "failed to parse migration file")
 	}
 
-	// Reset file cursor and read existing file content
-	if _, err = m.lockedFile.Seek(0, 0); err != nil {
-		return err
-	}
-
-	fileData, err := io.ReadAll(m.lockedFile)
+	// Read existing file content
+	fileData, err := m.readFile()
 	if err != nil {
 		return err
 	}
@@ -122,64 +302,172 @@ func (m *Config) Run(migration io.Reader) error {
 	if err := m.driver.Unmarshal(fileData, &fileMap); err != nil {
 		return errors.Wrapf(err, "failed to parse %s", m.path)
 	}
+	if aligner, ok := m.driver.(Aligner); ok {
+		fileMap = aligner.Align(migrMap, fileMap)
+	}
 
-	// One-time backup per migration run: save current file state (e.g. version 2) before applying any migration.
-	if m.backupBeforeMigrate && !m.backedUpThisSession {
-		version, _, _ := m.driver.Version(fileData)
-		if version < 0 {
-			version = 0
+	// Remove migration-specific metadata
+	versionKeys := map[string]interface{}{}
+	for _, key := range m.stateKeys() {
+		if v, ok := deleteValueByPath(fileMap, key); ok {
+			versionKeys[key] = v
 		}
-		backupPath := m.path + ".v" + strconv.Itoa(version) + ".backup"
-		if err := os.WriteFile(backupPath, fileData, m.perm); err != nil {
-			return errors.Wrapf(err, "backup before migrate: write %s", backupPath)
+		deleteValueByPath(migrMap, key)
+	}
+
+	// Merge current config and migration changes
+	opts := merger.Options{
+		ArrayMode: m.arrayMergeMode,
+		Strict:    m.strictMerge || m.onMergeWarning != nil,
+	}
+	var base map[string]interface{}
+	var trace merger.Trace
+	if m.onExplain != nil {
+		base, trace, err = merger.MergeWithTrace(migrMap, fileMap, opts)
+	} else {
+		base, err = merger.MergeWithErrors(migrMap, fileMap, opts)
+	}
+	var errs merger.DirectiveErrors
+	if errors.As(err, &errs) {
+		// A directive that cannot be applied would silently drop a value; the migration is not written
+		if m.strictMerge {
+			if rbErr := m.rollback(); rbErr != nil {
+				return errors.Wrapf(rbErr, "failed to restore %s after: %v", m.path, err)
+			}
+			return err
 		}
-		m.backedUpThisSession = true
+		m.onMergeWarning(errs)
+	} else if err != nil {
+		return err
 	}
 
-	// Remove migration-specific metadata
-	delete(migrMap, "force")
-	delete(fileMap, "force")
-	delete(migrMap, "version")
-	delete(fileMap, "version")
+	// A config the application would refuse is not written; the state before the migration is restored instead
+	if err := m.validate(base); err != nil {
+		if rbErr := m.rollback(); rbErr != nil {
+			return errors.Wrapf(rbErr, "failed to restore %s after: %v", m.path, err)
+		}
+		return err
+	}
 
-	// Merge current config and migration changes
-	base := merger.Merge(migrMap, fileMap)
+	if m.onDiff != nil {
+		if err := m.reportDiff(fileData, fileMap, base); err != nil {
+			return err
+		}
+	}
 
-	// Marshal merged data to bytes
-	data, err := m.driver.Marshal(base, false)
-	if err != nil {
+	if m.onExplain != nil {
+		m.reportExplanation(trace)
+	}
+
+	var newData string
+	if patcher, ok := m.patcher(); ok {
+		// Keep version and force where they are; SetVersion updates them after Run
+		for k, v := range versionKeys {
+			setValueByPath(base, k, v)
+		}
+
+		data, err := patcher.Patch(fileData, base, false)
+		if err != nil {
+			return err
+		}
+		newData = string(data)
+	} else {
+		// Marshal merged data to bytes
+		data, err := m.driver.Marshal(base, false)
+		if err != nil {
+			return err
+		}
+
+		// Clean up unwanted values in output
+		newData = string(CleanOutput(m.driver, data))
+	}
+
+	if m.dryRun != nil {
+		m.recordStep([]byte(newData))
+	}
+
+	// Atomically replace the file with new content
+	return m.writeFile([]byte(newData))
+}
+
+// SetVersion updates the current config file with version and dirty (force) flags.
+// A migration is started by a dirty SetVersion and finished by a clean one, which appends it to the history file.
+func (m *Config) SetVersion(version int, dirty bool) error {
+	if dirty {
+		// One-time backup per migration run: save current file state (e.g. version 2) before applying any migration.
+		if err := m.backup(); err != nil {
+			return err
+		}
+		if err := m.beginStep(); err != nil {
+			return err
+		}
+	}
+
+	if err := m.setVersion(version, dirty); err != nil {
 		return err
 	}
 
-	// Clean up unwanted values in output
-	newData := strings.ReplaceAll(string(data), "'", "")
-	newData = strings.ReplaceAll(newData, "null", "")
+	if step := m.step; step != nil && !dirty {
+		m.step = nil
+		return m.appendHistory(step, version)
+	}
+	return nil
+}
+
+// migrationStep is the migration in progress between a dirty and a clean SetVersion.
+type migrationStep struct {
+	from              int    // Version before the migration
+	fileData          []byte // Config content before the migration
+	migrationChecksum string // Checksum of the migration body passed to Run
+}
 
-	// Truncate and overwrite the file with new content
-	err = m.lockedFile.Truncate(0)
+// beginStep remembers the state a migration starts from.
+func (m *Config) beginStep() error {
+	fileData, err := m.readFile()
 	if err != nil {
 		return err
 	}
 
-	if _, err = m.lockedFile.Seek(0, 0); err != nil {
+	from, _, err := m.readVersion(fileData)
+	if err != nil {
 		return err
 	}
 
-	_, err = m.lockedFile.Write([]byte(newData))
-	return err
+	m.step = &migrationStep{from: from, fileData: fileData}
+	return nil
 }
 
-// SetVersion updates the current config file with version and dirty (force) flags.
-func (m *Config) SetVersion(version int, dirty bool) error {
-	if m.onlyOneVersion {
+// rollback restores the config content and version the migration in progress started from.
+func (m *Config) rollback() error {
+	step := m.step
+	if step == nil {
 		return nil
 	}
+	m.step = nil
 
-	if _, err := m.lockedFile.Seek(0, 0); err != nil {
+	if err := m.writeFile(step.fileData); err != nil {
 		return err
 	}
 
-	fileData, err := io.ReadAll(m.lockedFile)
+	if m.versionFile == "" {
+		return nil
+	}
+	if step.from == database.NilVersion {
+		return m.dropVersion()
+	}
+	_, err := m.writeVersion(nil, step.from, false)
+	return err
+}
+
+// setVersion stores version and dirty and rewrites the config file if they are stored in it.
+func (m *Config) setVersion(version int, dirty bool) error {
+	m.target = version
+
+	if m.onlyOneVersion {
+		return nil
+	}
+
+	fileData, err := m.readFile()
 	if err != nil {
 		return err
 	}
@@ -189,28 +477,23 @@ func (m *Config) SetVersion(version int, dirty bool) error {
 		return errors.Wrapf(err, "failed to parse %s", m.path)
 	}
 
-	fileMap["version"] = version
-	fileMap["force"] = dirty
-
-	data, err := m.driver.Marshal(fileMap, m.unableToReplaceComments)
+	changed, err := m.writeVersion(fileMap, version, dirty)
 	if err != nil {
 		return err
 	}
 
-	newData := strings.ReplaceAll(string(data), "null", "")
-
-	// Truncate and overwrite with updated version
-	err = m.lockedFile.Truncate(0)
-	if err != nil {
-		return err
+	// With a sidecar version file the config only needs rewriting to turn comment keys into comments
+	if !changed && !m.unableToReplaceComments {
+		return nil
 	}
 
-	if _, err = m.lockedFile.Seek(0, 0); err != nil {
+	newData, err := m.render(fileData, fileMap)
+	if err != nil {
 		return err
 	}
 
-	_, err = m.lockedFile.Write([]byte(newData))
-	return err
+	// Atomically replace the file with updated version
+	return m.writeFile(newData)
 }
 
 // Version reads and returns the current migration version and dirty flag.
@@ -219,61 +502,37 @@ func (m *Config) Version() (int, bool, error) {
 		return 0, false, nil
 	}
 
-	if _, err := m.lockedFile.Seek(0, 0); err != nil {
-		if errors.Is(err, fs.ErrClosed) {
-			// If file is closed, reopen and lock it
-			if err := m.Lock(); err != nil {
-				return 0, false, err
-			}
-			defer m.Unlock()
-
-			if _, err := m.lockedFile.Seek(0, 0); err != nil {
-				return 0, false, err
-			}
-		} else {
+	r, err := m.readFile()
+	if errors.Is(err, fs.ErrClosed) {
+		// If file is closed, reopen and lock it
+		if err := m.Lock(); err != nil {
 			return 0, false, err
 		}
-	}
-
-	r, err := io.ReadAll(m.lockedFile)
-	if err != nil {
-		return 0, false, err
-	}
+		defer m.Unlock()
 
-	if len(r) == 0 {
-		return database.NilVersion, false, nil
+		r, err = m.readFile()
 	}
-
-	version, force, err := m.driver.Version(r)
 	if err != nil {
 		return 0, false, err
 	}
 
-	if version == 0 {
-		return database.NilVersion, false, nil
-	}
-
-	return version, force, nil
+	return m.readVersion(r)
 }
 
-// Drop resets the config file by truncating it and writing empty/default content.
+// Drop resets the config file by atomically replacing it with empty/default content.
 func (m *Config) Drop() error {
-	err := m.lockedFile.Truncate(0)
-	if err != nil {
+	if err := m.writeFile(m.driver.EmptyData()); err != nil {
 		return err
 	}
 
-	if _, err = m.lockedFile.Seek(0, 0); err != nil {
-		return err
-	}
+	return m.dropVersion()
+}
 
-	emptyData := m.driver.EmptyData()
-	if emptyData != nil && len(emptyData) > 0 {
-		_, err = m.lockedFile.Write(emptyData)
-		if err != nil {
-			return err
-		}
+// patcher returns the driver as a Patcher when formatting should be preserved and the driver supports it.
+func (m *Config) patcher() (Patcher, bool) {
+	if !m.preserveFormatting {
+		return nil, false
 	}
-
-	return nil
+	p, ok := m.driver.(Patcher)
+	return p, ok
 }
@@ -2,13 +2,19 @@ package config_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	cfg "github.com/c2pc/config-migrate/driver"
 	jsonDriver "github.com/c2pc/config-migrate/driver/json"
+	"github.com/c2pc/config-migrate/merger"
 	"github.com/golang-migrate/migrate/v4/database"
 )
 
@@ -206,6 +212,25 @@ func TestVersion_and_SetVersion(t *testing.T) {
 	}
 }
 
+// TestVersion_beforeLock reads the version of a file that was not locked yet.
+func TestVersion_beforeLock(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	writeJSON(t, path, map[string]interface{}{"version": 3, "force": false})
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path})
+	d, _ := c.Open("json://" + path)
+	v, dirty, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 3 || dirty {
+		t.Errorf("expected version=3 dirty=false, got %d %t", v, dirty)
+	}
+	if err := d.Run(bytes.NewBufferString(`{"port": 80}`)); err == nil {
+		t.Error("expected an error for Run before Lock")
+	}
+}
+
 // TestDrop truncates file and writes empty data.
 func TestDrop(t *testing.T) {
 	tmp := t.TempDir()
@@ -253,6 +278,782 @@ func TestOnlyOneVersion(t *testing.T) {
 	}
 }
 
+// TestRun_atomicWrite replaces the file via rename: mode is kept and no temp files are left behind.
+func TestRun_atomicWrite(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	writeJSON(t, path, map[string]interface{}{"a": "old"})
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path})
+	d, _ := c.Open("json://" + path)
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Run(bytes.NewBufferString(`{"a": "new", "b": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Error("expected config to be replaced by a new file")
+	}
+	if err := d.SetVersion(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	after, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Mode().Perm() != 0640 {
+		t.Errorf("expected mode 0640, got %v", after.Mode().Perm())
+	}
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the config file in dir, got %d entries", len(entries))
+	}
+	got := readJSON(t, path)
+	if got["a"] != "old" || got["b"].(float64) != 1 || got["version"].(float64) != 1 {
+		t.Errorf("unexpected content %v", got)
+	}
+}
+
+// TestLock_afterReplace locks the replaced file on the next Lock, not the stale one.
+func TestLock_afterReplace(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	writeJSON(t, path, map[string]interface{}{"version": 1, "force": false})
+	first := cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path})
+	second := cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path})
+	if err := first.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan error, 1)
+	go func() {
+		locked <- second.Lock()
+	}()
+
+	if err := first.SetVersion(2, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-locked; err != nil {
+		t.Fatal(err)
+	}
+	defer second.Unlock()
+
+	v, _, err := second.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 2 {
+		t.Errorf("expected version 2 from replaced file, got %d", v)
+	}
+}
+
+// TestVersionFile stores version and force in a sidecar file and leaves application keys alone.
+func TestVersionFile(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	versionPath := path + ".migrate.json"
+	writeJSON(t, path, map[string]interface{}{"version": "1.2.0"})
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path, VersionFile: versionPath})
+	if err := c.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Unlock()
+	v, _, err := c.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != database.NilVersion {
+		t.Errorf("expected NilVersion without sidecar, got %d", v)
+	}
+	if err := c.Run(bytes.NewBufferString(`{"version": "", "port": 80}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetVersion(3, true); err != nil {
+		t.Fatal(err)
+	}
+	got := readJSON(t, path)
+	if got["version"] != "1.2.0" || got["port"].(float64) != 80 {
+		t.Errorf("unexpected config %v", got)
+	}
+	if _, ok := got["force"]; ok {
+		t.Errorf("expected no force key in config, got %v", got)
+	}
+	state := readJSON(t, versionPath)
+	if state["version"].(float64) != 3 || state["force"] != true {
+		t.Errorf("unexpected sidecar %v", state)
+	}
+	v, dirty, err := c.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 3 || !dirty {
+		t.Errorf("expected version=3 dirty=true, got %d %t", v, dirty)
+	}
+	if err := c.Drop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(versionPath); !os.IsNotExist(err) {
+		t.Errorf("expected sidecar to be removed by Drop, got %v", err)
+	}
+}
+
+// TestVersionKey stores version and force under a configurable key path.
+func TestVersionKey(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	writeJSON(t, path, map[string]interface{}{"version": "1.2.0", "_meta": map[string]interface{}{"schema_version": 2}})
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{})
+	d, err := c.Open("json://" + path + "?x-version-key=_meta.schema_version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Unlock()
+	v, dirty, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 2 || dirty {
+		t.Errorf("expected version=2 dirty=false, got %d %t", v, dirty)
+	}
+	if err := d.Run(bytes.NewBufferString(`{"version": "", "port": 80}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetVersion(3, false); err != nil {
+		t.Fatal(err)
+	}
+	got := readJSON(t, path)
+	meta, _ := got["_meta"].(map[string]interface{})
+	if got["version"] != "1.2.0" || meta["schema_version"].(float64) != 3 || meta["force"] != false {
+		t.Errorf("unexpected config %v", got)
+	}
+}
+
+// TestDryRun merges migrations in memory, reports each step and leaves the config and sidecar files untouched.
+func TestDryRun(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	versionPath := path + ".migrate.json"
+	writeJSON(t, path, map[string]interface{}{"port": 80})
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{})
+	d, err := c.Open("json://" + path + "?x-dry-run=true&x-version-file=" + versionPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	for i, migration := range []string{`{"port": 8080, "host": "localhost"}`, `{"port": 8080, "host": "localhost", "debug": true}`} {
+		if err := d.SetVersion(i+1, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Run(bytes.NewBufferString(migration)); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.SetVersion(i+1, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, dirty, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 2 || dirty {
+		t.Errorf("expected would-be version=2 dirty=false, got %d %t", v, dirty)
+	}
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	steps, data, err := d.(*cfg.Config).Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].Version != 1 || steps[1].Version != 2 {
+		t.Fatalf("unexpected steps %+v", steps)
+	}
+	if !bytes.Contains(steps[0].Data, []byte(`"host"`)) || bytes.Contains(steps[0].Data, []byte(`"debug"`)) {
+		t.Errorf("unexpected first step %s", steps[0].Data)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["port"].(float64) != 80 || got["host"] != "localhost" || got["debug"] != true {
+		t.Errorf("unexpected would-be config %v", got)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("config changed by dry run: %s", after)
+	}
+	if _, err := os.Stat(versionPath); !os.IsNotExist(err) {
+		t.Errorf("expected no sidecar after dry run, got %v", err)
+	}
+}
+
+// TestDryRun_notSticky opens the same driver without x-dry-run after a dry run and writes the config.
+func TestDryRun_notSticky(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeJSON(t, path, map[string]interface{}{"port": 80})
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{})
+
+	for _, dryRun := range []bool{true, false} {
+		url := "json://" + path
+		if dryRun {
+			url += "?x-dry-run=true"
+		}
+		d, err := c.Open(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Lock(); err != nil {
+			t.Fatal(err)
+		}
+		migrateJSON(t, d, 1, `{"port": 8080}`)
+		if err := d.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := readJSON(t, path); got["version"] != float64(1) || got["port"] != float64(80) {
+		t.Errorf("expected the migration without x-dry-run to be written, got %v", got)
+	}
+}
+
+// TestDryRun_missingFile plans migrations for a config file that does not exist without creating it.
+func TestDryRun_missingFile(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path, DryRun: true})
+	if err := c.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	v, _, err := c.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != database.NilVersion {
+		t.Errorf("expected NilVersion, got %d", v)
+	}
+	if err := c.Run(bytes.NewBufferString(`{"port": 8080}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetVersion(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected config not to be created by dry run, got %v", err)
+	}
+	_, data, err := c.(*cfg.Config).Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["port"].(float64) != 8080 || got["version"].(float64) != 1 {
+		t.Errorf("unexpected would-be config %v", got)
+	}
+}
+
+// TestOnDiff reports changed key paths and a unified diff for every migration.
+func TestOnDiff(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	writeJSON(t, path, map[string]interface{}{"version": 1, "force": false, "port": 80, "http": map[string]interface{}{"host": "a", "tls": true}})
+	var diffs []cfg.Diff
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path, OnDiff: func(d cfg.Diff) {
+		diffs = append(diffs, d)
+	}})
+	if err := c.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Unlock()
+	if err := c.SetVersion(2, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(bytes.NewBufferString(`{"port": 8080, "http": {"host": "b", "host_deprecated_replace": "", "timeout": 30}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 {
+		t.Fatalf("expected 1 diff, got %d", len(diffs))
+	}
+	d := diffs[0]
+	if d.Version != 2 {
+		t.Errorf("expected version 2, got %d", d.Version)
+	}
+	expected := []cfg.Change{
+		{Path: "http.host", Kind: cfg.ChangeChanged, Old: "a", New: "b"},
+		{Path: "http.timeout", Kind: cfg.ChangeAdded, New: float64(30)},
+		{Path: "http.tls", Kind: cfg.ChangeRemoved, Old: true},
+	}
+	if len(d.Changes) != len(expected) {
+		t.Fatalf("expected changes %v, got %v", expected, d.Changes)
+	}
+	for i, c := range expected {
+		if d.Changes[i] != c {
+			t.Errorf("expected change %v, got %v", c, d.Changes[i])
+		}
+	}
+	for _, want := range []string{"--- " + path, "+++ " + path, `-        "tls": true`, `+        "timeout": 30`} {
+		if !strings.Contains(d.Unified, want) {
+			t.Errorf("expected %q in unified diff:\n%s", want, d.Unified)
+		}
+	}
+	if strings.Contains(d.Unified, "version") {
+		t.Errorf("expected version keys to be left out of the diff:\n%s", d.Unified)
+	}
+}
+
+// TestHistoryFile appends every finished migration to the history file; forcing a version is not recorded.
+// TestOnExplain reports the origin of every key after a migration that renames a key.
+func TestOnExplain(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	writeJSON(t, path, map[string]interface{}{"version": 1, "force": false, "port": 80, "dsn": "postgres://db/app"})
+	var explanations []cfg.Explanation
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path, OnExplain: func(e cfg.Explanation) {
+		explanations = append(explanations, e)
+	}})
+	if err := c.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Unlock()
+	if err := c.SetVersion(2, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Run(bytes.NewBufferString(`{"port": 8080, "db": {"url": "", "url_deprecated": "dsn", "url______": "Database URL"}, "timeout": 30}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(explanations) != 1 {
+		t.Fatalf("expected 1 explanation, got %d", len(explanations))
+	}
+	e := explanations[0]
+	if e.Version != 2 {
+		t.Errorf("expected version 2, got %d", e.Version)
+	}
+	expected := merger.Trace{
+		{Path: "db.url", Source: merger.SourceDeprecated, Directive: "db.url_deprecated", From: "dsn", Value: "postgres://db/app"},
+		{Path: "port", Source: merger.SourceOld, Value: float64(80)},
+		{Path: "timeout", Source: merger.SourceNew, Value: float64(30)},
+	}
+	if len(e.Trace) != len(expected) {
+		t.Fatalf("expected trace %v, got %v", expected, e.Trace)
+	}
+	for i, entry := range expected {
+		if e.Trace[i] != entry {
+			t.Errorf("expected %v, got %v", entry, e.Trace[i])
+		}
+	}
+}
+
+func TestHistoryFile(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	historyPath := path + ".history.jsonl"
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{})
+	d, err := c.Open("json://" + path + "?x-history-file=" + historyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Unlock()
+	migrations := []struct {
+		version   int
+		migration string
+	}{
+		{1, `{"port": 80}`},
+		{2, `{"port": 80, "host": "localhost"}`},
+		{1, `{"port": 80}`},
+	}
+	for _, mg := range migrations {
+		if err := d.SetVersion(mg.version, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Run(bytes.NewBufferString(mg.migration)); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.SetVersion(mg.version, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.SetVersion(5, false); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := d.(*cfg.Config).History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %+v", entries)
+	}
+	directions := []string{cfg.DirectionUp, cfg.DirectionUp, cfg.DirectionDown}
+	hostname, _ := os.Hostname()
+	for i, e := range entries {
+		if e.Version != migrations[i].version || e.Direction != directions[i] {
+			t.Errorf("entry %d: expected version=%d direction=%s, got %+v", i, migrations[i].version, directions[i], e)
+		}
+		if e.Hostname != hostname || e.Time.IsZero() {
+			t.Errorf("entry %d: unexpected hostname or time %+v", i, e)
+		}
+		sum := sha256.Sum256([]byte(migrations[i].migration))
+		if e.MigrationChecksum != hex.EncodeToString(sum[:]) {
+			t.Errorf("entry %d: unexpected migration checksum %s", i, e.MigrationChecksum)
+		}
+		if len(e.FileChecksum) != 64 {
+			t.Errorf("entry %d: unexpected file checksum %q", i, e.FileChecksum)
+		}
+	}
+	if entries[0].FileChecksum == entries[1].FileChecksum {
+		t.Error("expected file checksums to differ between versions")
+	}
+}
+
+// migrateJSON applies migration to version the way golang-migrate does.
+func migrateJSON(t *testing.T, d database.Driver, version int, migration string) {
+	t.Helper()
+	if err := d.SetVersion(version, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Run(bytes.NewBufferString(migration)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetVersion(version, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestBackup backs up the config once per Lock session into the backup directory and keeps only the newest backups.
+func TestBackup(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	backupDir := filepath.Join(tmp, "backups")
+	writeJSON(t, path, map[string]interface{}{"version": 1, "force": false, "port": 80})
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path, BackupBeforeMigrate: true, BackupDir: backupDir, BackupKeep: 2})
+	for session, version := range []int{2, 4, 6} {
+		if err := c.Lock(); err != nil {
+			t.Fatal(err)
+		}
+		migrateJSON(t, c, version, `{"port": 8080, "step": 1}`)
+		migrateJSON(t, c, version+1, `{"port": 8080, "step": 2}`)
+		if err := c.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+		if session == 0 {
+			// A legacy backup without a time is older than any new one
+			if err := os.WriteFile(filepath.Join(backupDir, "config.json.v0.backup"), []byte(`{}`), 0600); err != nil {
+				t.Fatal(err)
+			}
+			old := time.Now().Add(-time.Hour)
+			if err := os.Chtimes(filepath.Join(backupDir, "config.json.v0.backup"), old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	backups, err := c.(*cfg.Config).Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0].Version != 3 || backups[1].Version != 5 {
+		t.Fatalf("expected backups of versions 3 and 5, got %+v", backups)
+	}
+	data := readJSON(t, backups[1].Path)
+	if data["version"].(float64) != 5 || data["force"] != false {
+		t.Errorf("expected the clean state before the migration run, got %v", data)
+	}
+	if !backups[0].Time.Before(backups[1].Time) {
+		t.Errorf("expected backups oldest first, got %+v", backups)
+	}
+}
+
+// TestRestore restores a backup by version and by file and stores its version as not dirty.
+func TestRestore(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	versionPath := path + ".migrate.json"
+	writeJSON(t, path, map[string]interface{}{"port": 80})
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path, VersionFile: versionPath, BackupBeforeMigrate: true})
+	for version := 1; version <= 3; version++ {
+		if err := c.Lock(); err != nil {
+			t.Fatal(err)
+		}
+		migrateJSON(t, c, version, fmt.Sprintf(`{"port": 80, "v%d": true}`, version))
+		if err := c.Unlock(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := c.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetVersion(4, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := c.(*cfg.Config).Restore("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Version != 1 {
+		t.Errorf("expected backup of version 1, got %+v", b)
+	}
+	got := readJSON(t, path)
+	if got["v1"] != true || got["v2"] != nil {
+		t.Errorf("unexpected restored config %v", got)
+	}
+	state := readJSON(t, versionPath)
+	if state["version"].(float64) != 1 || state["force"] != false {
+		t.Errorf("unexpected sidecar %v", state)
+	}
+
+	backups, err := c.(*cfg.Config).Backups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.(*cfg.Config).Restore(backups[0].Path); err != nil {
+		t.Fatal(err)
+	}
+	if got := readJSON(t, path); len(got) != 1 || got["port"].(float64) != 80 {
+		t.Errorf("unexpected restored config %v", got)
+	}
+	if _, err := os.Stat(versionPath); !os.IsNotExist(err) {
+		t.Errorf("expected sidecar to be removed for a backup without version, got %v", err)
+	}
+
+	if _, err := c.(*cfg.Config).Restore("7"); err == nil {
+		t.Error("expected error for a version without backup")
+	}
+}
+
+// TestSchema rejects a migration producing an invalid config, lists the failing key paths and restores the sidecar version.
+func TestSchema(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	versionPath := path + ".migrate.json"
+	schemaPath := filepath.Join(tmp, "schema.json")
+	schema := `{"type": "object", "properties": {"http": {"type": "object", "properties": {"port": {"type": "integer"}}}, "name": {"type": "string"}}}`
+	if err := os.WriteFile(schemaPath, []byte(schema), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{})
+	d, err := c.Open("json://" + path + "?x-version-file=" + versionPath + "&x-schema-file=" + schemaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Unlock()
+	migrateJSON(t, d, 1, `{"http": {"port": 80}, "name": "app", "name______": "comment keys are not validated"}`)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.SetVersion(2, true); err != nil {
+		t.Fatal(err)
+	}
+	err = d.Run(bytes.NewBufferString(`{"http": {"port": "80", "port_deprecated_replace": ""}, "name": 1, "name_deprecated_replace": ""}`))
+	schemaErr, ok := err.(*cfg.SchemaError)
+	if !ok {
+		t.Fatalf("expected SchemaError, got %v", err)
+	}
+	if len(schemaErr.Errors) != 2 || !strings.HasPrefix(schemaErr.Errors[0], "http.port: expected integer") || !strings.HasPrefix(schemaErr.Errors[1], "name: expected string") {
+		t.Errorf("unexpected errors %q", schemaErr.Errors)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("expected config to be restored, got %s", after)
+	}
+	v, dirty, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 1 || dirty {
+		t.Errorf("expected version=1 dirty=false, got %d %t", v, dirty)
+	}
+}
+
+// TestSchema_commentsInArrays validates elements of arrays without their comment keys.
+func TestSchema_commentsInArrays(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	schema := `{"type": "object", "properties": {"servers": {"type": "array", "items": {"type": "object", "properties": {"host": {"type": "string"}}, "additionalProperties": false}}}}`
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path, VersionFile: path + ".migrate.json", Schema: []byte(schema)})
+	d, err := c.Open("json://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Unlock()
+	migrateJSON(t, d, 1, `{"servers": [{"host": "localhost", "host______": "comment keys are not validated"}]}`)
+
+	v, _, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 1 {
+		t.Errorf("expected version=1, got %d", v)
+	}
+}
+
+// TestArrayMergeMode adds a default entry to an allow-list edited by the operator.
+func TestArrayMergeMode(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	writeJSON(t, path, map[string]interface{}{"allow": []interface{}{"10.0.0.1", "10.0.0.9"}})
+
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path, ArrayMergeMode: merger.ArrayAppend})
+	d, err := c.Open("json://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Unlock()
+
+	migrateJSON(t, d, 1, `{"allow": ["10.0.0.1", "127.0.0.1"], "deny": ["0.0.0.0"]}`)
+
+	got, _ := json.Marshal(readJSON(t, path)["allow"])
+	if string(got) != `["10.0.0.1","10.0.0.9","127.0.0.1"]` {
+		t.Errorf("expected the default entry to be appended, got %s", got)
+	}
+
+	// The query parameter overrides the setting
+	if _, err := c.Open("json://" + path + "?x-array-merge-mode=replace"); err != nil {
+		t.Fatal(err)
+	}
+	migrateJSON(t, d, 2, `{"allow": ["127.0.0.1"]}`)
+
+	got, _ = json.Marshal(readJSON(t, path)["allow"])
+	if string(got) != `["127.0.0.1"]` {
+		t.Errorf("expected the allow-list to be replaced, got %s", got)
+	}
+
+	if _, err := c.Open("json://" + path + "?x-array-merge-mode=sideways"); err == nil {
+		t.Error("expected an error for an unknown array merge mode")
+	}
+}
+
+// TestStrictMerge renames a key with a typo in the source path of the migration.
+func TestStrictMerge(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "config.json")
+	migration := `{"db": {"url": "", "url_deprecated": "db.dnss"}}`
+
+	c := cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path, StrictMerge: true})
+	d, err := c.Open("json://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	migrateJSON(t, d, 1, `{"db": {"dsn": "postgres://db/app"}}`)
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.SetVersion(2, true); err != nil {
+		t.Fatal(err)
+	}
+	err = d.Run(bytes.NewBufferString(migration))
+	errs, ok := err.(merger.DirectiveErrors)
+	if !ok {
+		t.Fatalf("expected DirectiveErrors, got %v", err)
+	}
+	if len(errs) != 1 || errs[0].Key != "db.url_deprecated" || errs[0].Path != "db.dnss" {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Errorf("expected config to be restored, got %s", after)
+	}
+	v, dirty, err := d.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 1 || dirty {
+		t.Errorf("expected version=1 dirty=false, got %d %t", v, dirty)
+	}
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Not strict: the migration is applied and the problems are passed to OnMergeWarning
+	var warnings merger.DirectiveErrors
+	c = cfg.New(&jsonDriver.Json{}, cfg.Settings{Path: path, OnMergeWarning: func(errs merger.DirectiveErrors) {
+		warnings = append(warnings, errs...)
+	}})
+	d, err = c.Open("json://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Unlock()
+	migrateJSON(t, d, 2, migration)
+	if len(warnings) != 1 || warnings[0].Key != "db.url_deprecated" {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+	if _, ok := readJSON(t, path)["db"].(map[string]interface{})["url"]; !ok {
+		t.Error("expected the migration to be applied")
+	}
+}
+
 func writeJSON(t *testing.T, path string, m map[string]interface{}) {
 	t.Helper()
 	data, err := json.Marshal(m)
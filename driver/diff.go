@@ -0,0 +1,143 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// ChangeKind describes how a key path was changed by a migration.
+type ChangeKind string
+
+const (
+	ChangeAdded   ChangeKind = "added"
+	ChangeRemoved ChangeKind = "removed"
+	ChangeChanged ChangeKind = "changed"
+)
+
+// Change is a single key path changed by a migration.
+type Change struct {
+	// Path — dot-separated key path, e.g. "http.port".
+	Path string
+
+	// Kind — whether the key was added, removed or changed.
+	Kind ChangeKind
+
+	// Old — the value before the migration; nil if the key was added.
+	Old interface{}
+
+	// New — the value after the migration; nil if the key was removed.
+	New interface{}
+}
+
+// Diff describes the changes one migration applied to the config.
+type Diff struct {
+	// Version — the version the migration leads to.
+	Version int
+
+	// Changes — changed key paths sorted by path. Version keys and comment keys are not included.
+	Changes []Change
+
+	// Unified — unified diff of the serialized config before and after the migration.
+	Unified string
+}
+
+// SetDiffHandler sets the function called with the Diff of every migration applied by Run, like Settings.OnDiff.
+func (m *Config) SetDiffHandler(fn func(Diff)) {
+	m.onDiff = fn
+}
+
+// reportDiff calls the diff handler with the changes between oldMap and newMap, the config before and after a migration.
+// fileData is the config content the maps were read from.
+func (m *Config) reportDiff(fileData []byte, oldMap, newMap map[string]interface{}) error {
+	before, err := m.render(fileData, oldMap)
+	if err != nil {
+		return err
+	}
+
+	after, err := m.render(fileData, newMap)
+	if err != nil {
+		return err
+	}
+
+	unified, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(before)),
+		B:        difflib.SplitLines(string(after)),
+		FromFile: m.path,
+		ToFile:   m.path,
+		Context:  3,
+	})
+	if err != nil {
+		return err
+	}
+
+	m.onDiff(Diff{
+		Version: m.target,
+		Changes: diffMaps(oldMap, newMap),
+		Unified: unified,
+	})
+	return nil
+}
+
+// render serializes data the way SetVersion writes it, so both sides of a diff are formatted alike.
+func (m *Config) render(fileData []byte, data map[string]interface{}) ([]byte, error) {
+	if patcher, ok := m.patcher(); ok {
+		return patcher.Patch(fileData, data, m.unableToReplaceComments)
+	}
+
+	out, err := m.driver.Marshal(data, m.unableToReplaceComments)
+	if err != nil {
+		return nil, err
+	}
+	return CleanOutput(m.driver, out), nil
+}
+
+// diffMaps returns the changes between the leaf values of old and new sorted by path.
+func diffMaps(old, new map[string]interface{}) []Change {
+	oldLeaves := map[string]interface{}{}
+	flatten(oldLeaves, "", old)
+	newLeaves := map[string]interface{}{}
+	flatten(newLeaves, "", new)
+
+	var changes []Change
+	for path, o := range oldLeaves {
+		n, ok := newLeaves[path]
+		switch {
+		case !ok:
+			changes = append(changes, Change{Path: path, Kind: ChangeRemoved, Old: o})
+		case !reflect.DeepEqual(o, n):
+			changes = append(changes, Change{Path: path, Kind: ChangeChanged, Old: o, New: n})
+		}
+	}
+	for path, n := range newLeaves {
+		if _, ok := oldLeaves[path]; !ok {
+			changes = append(changes, Change{Path: path, Kind: ChangeAdded, New: n})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// flatten stores the leaf values of m in leaves by dot-separated path. Non-empty maps are descended into;
+// arrays and empty maps are leaves. Comment keys are skipped.
+func flatten(leaves map[string]interface{}, prefix string, m map[string]interface{}) {
+	for k, v := range m {
+		if strings.Contains(k, CommentSuffix) {
+			continue
+		}
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if child, ok := v.(map[string]interface{}); ok && len(child) > 0 {
+			flatten(leaves, path, child)
+			continue
+		}
+		leaves[path] = v
+	}
+}
@@ -0,0 +1,149 @@
+package dir
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/c2pc/config-migrate/driver"
+	"github.com/pkg/errors"
+)
+
+// journalFile is the name of the file in the directory that lists the steps of a commit in progress.
+const journalFile = ".migrate.journal"
+
+// journal describes a commit: the temp files to rename over their targets and the files to remove.
+// Paths are slash-separated and relative to the directory.
+type journal struct {
+	Writes  map[string]string `json:"writes"`
+	Removes []string          `json:"removes,omitempty"`
+}
+
+// commit replaces the files of writes and removes the files of removes, all or none.
+// New contents are first written to temp files next to their targets. Once all of them are synced, the journal
+// is written; from then on the commit is completed even after a crash, by recoverCommit on the next Lock.
+func commit(dir string, writes map[string][]byte, removes []string, perm os.FileMode) error {
+	j := journal{Writes: make(map[string]string, len(writes)), Removes: removes}
+
+	cleanup := func() {
+		for _, tmp := range j.Writes {
+			os.Remove(filepath.Join(dir, filepath.FromSlash(tmp)))
+		}
+	}
+
+	targets := make([]string, 0, len(writes))
+	for rel := range writes {
+		targets = append(targets, rel)
+	}
+	sort.Strings(targets)
+
+	for _, rel := range targets {
+		tmp, err := writeTemp(dir, rel, writes[rel], perm)
+		if err != nil {
+			cleanup()
+			return errors.Wrapf(err, "failed to write %s", rel)
+		}
+		j.Writes[rel] = tmp
+	}
+
+	data, err := json.MarshalIndent(j, "", "    ")
+	if err != nil {
+		cleanup()
+		return err
+	}
+	if err := writeJournal(dir, data); err != nil {
+		cleanup()
+		return err
+	}
+
+	return apply(dir, j)
+}
+
+// writeTemp writes data to a synced temp file next to the target rel and returns the temp file path relative to dir.
+// The temp file gets the mode of the existing target, or perm for a new one.
+func writeTemp(dir, rel string, data []byte, perm os.FileMode) (string, error) {
+	target := filepath.Join(dir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return "", err
+	}
+
+	mode := perm
+	if info, err := os.Stat(target); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmpPath, err := config.WriteTempFile(target, data, mode)
+	if err != nil {
+		return "", err
+	}
+
+	tmp, err := filepath.Rel(dir, tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", err
+	}
+	return filepath.ToSlash(tmp), nil
+}
+
+// writeJournal atomically writes the journal, so it is either complete or missing.
+func writeJournal(dir string, data []byte) error {
+	return config.WriteFileAtomic(filepath.Join(dir, journalFile), data, 0600)
+}
+
+// apply renames the temp files over their targets, removes the files to remove and then the journal.
+// A temp file that no longer exists was renamed before a crash. apply can be repeated until it succeeds.
+func apply(dir string, j journal) error {
+	dirs := map[string]bool{dir: true}
+
+	targets := make([]string, 0, len(j.Writes))
+	for rel := range j.Writes {
+		targets = append(targets, rel)
+	}
+	sort.Strings(targets)
+
+	for _, rel := range targets {
+		target := filepath.Join(dir, filepath.FromSlash(rel))
+		err := os.Rename(filepath.Join(dir, filepath.FromSlash(j.Writes[rel])), target)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to replace %s", rel)
+		}
+		dirs[filepath.Dir(target)] = true
+	}
+
+	for _, rel := range j.Removes {
+		target := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to remove %s", rel)
+		}
+		dirs[filepath.Dir(target)] = true
+	}
+
+	for d := range dirs {
+		if err := config.SyncDir(d); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "sync %s", d)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(dir, journalFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return config.SyncDir(dir)
+}
+
+// recoverCommit completes a commit whose journal was written but which was interrupted before it finished.
+func recoverCommit(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, journalFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	j := journal{}
+	if err := json.Unmarshal(data, &j); err != nil {
+		return errors.Wrapf(err, "failed to parse %s", journalFile)
+	}
+	return apply(dir, j)
+}
@@ -0,0 +1,334 @@
+// Package dir migrates a directory of config files, such as conf.d fragments, as a single unit.
+// A migration is a YAML mapping from the path of a file relative to the directory to the content of that file:
+//
+//	conf.d/server.yaml:
+//	  http:
+//	    port: 8080
+//	conf.d/db.json:
+//	  dsn: postgres://localhost/app
+//
+// Every file is merged with its current content like a single config file and written with the driver registered
+// for its extension. Files managed by an earlier migration that are missing from the migration are removed.
+// The version is stored once for the whole directory, and all files of a migration are replaced together.
+package dir
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	config "github.com/c2pc/config-migrate/driver"
+	iniDriver "github.com/c2pc/config-migrate/driver/ini"
+	jsonDriver "github.com/c2pc/config-migrate/driver/json"
+	yamlDriver "github.com/c2pc/config-migrate/driver/yaml"
+	"github.com/c2pc/config-migrate/internal/url"
+	"github.com/c2pc/config-migrate/merger"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/pkg/errors"
+	lockedFile "github.com/rogpeppe/go-internal/lockedfile"
+	"gopkg.in/yaml.v3"
+)
+
+// StateFile is the name of the file in the directory that stores version, force and the managed files.
+const StateFile = ".migrate.json"
+
+// lockFile is the name of the file in the directory that is locked while migrating.
+const lockFile = ".migrate.lock"
+
+var (
+	extMu      sync.RWMutex
+	extensions = map[string]config.Driver{
+		".json": &jsonDriver.Json{},
+		".yaml": &yamlDriver.Yaml{},
+		".yml":  &yamlDriver.Yaml{},
+		".ini":  &iniDriver.Ini{},
+	}
+)
+
+// RegisterExtension makes files with the extension ext, e.g. ".toml", be read and written with driver.
+func RegisterExtension(ext string, driver config.Driver) {
+	extMu.Lock()
+	defer extMu.Unlock()
+	extensions[strings.ToLower(ext)] = driver
+}
+
+func driverFor(name string) (config.Driver, error) {
+	extMu.RLock()
+	defer extMu.RUnlock()
+	d, ok := extensions[strings.ToLower(filepath.Ext(name))]
+	if !ok {
+		return nil, fmt.Errorf("dir: no driver for %s", name)
+	}
+	return d, nil
+}
+
+// Dir implements database.Driver for a directory of config files.
+type Dir struct {
+	mu                      sync.Mutex
+	lockedFile              *lockedFile.File
+	path                    string
+	perm                    fs.FileMode
+	unableToReplaceComments bool
+	arrayMergeMode          merger.ArrayMode
+}
+
+func init() {
+	database.Register("dir", New(config.Settings{}))
+}
+
+// New returns a database.Driver for the directory at cfg.Path. Perm is used for new files, UnableToReplaceComments
+// and ArrayMergeMode as for a single config file; the other settings are not supported.
+func New(cfg config.Settings) database.Driver {
+	path, err := url.ParseURL(cfg.Path)
+	if err != nil {
+		panic(err)
+	}
+
+	perm := config.DefaultPerm
+	if cfg.Perm != 0 {
+		perm = cfg.Perm
+	}
+
+	mode, err := merger.ParseArrayMode(string(cfg.ArrayMergeMode))
+	if err != nil {
+		panic(err)
+	}
+
+	return &Dir{
+		path:                    path,
+		perm:                    perm,
+		unableToReplaceComments: cfg.UnableToReplaceComments,
+		arrayMergeMode:          mode,
+	}
+}
+
+// Open sets the directory from a URL like "dir://conf" and returns the current instance.
+func (m *Dir) Open(dirPath string) (database.Driver, error) {
+	path, err := url.ParseURL(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	m.path = path
+	return m, nil
+}
+
+// Close closes the lock file if open.
+func (m *Dir) Close() error {
+	if m.lockedFile == nil {
+		return nil
+	}
+
+	err := m.lockedFile.Close()
+	m.lockedFile = nil
+	return err
+}
+
+// Lock creates the directory if needed, locks it and completes a commit interrupted by a crash.
+func (m *Dir) Lock() error {
+	if err := os.MkdirAll(m.path, 0777); err != nil {
+		return err
+	}
+
+	f, err := lockedFile.OpenFile(filepath.Join(m.path, lockFile), os.O_RDWR|os.O_CREATE, m.perm)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.lockedFile = f
+
+	if err := recoverCommit(m.path); err != nil {
+		m.mu.Unlock()
+		m.Close()
+		return err
+	}
+	return nil
+}
+
+// Unlock releases the mutex and closes the lock file.
+func (m *Dir) Unlock() error {
+	m.mu.Unlock()
+	return m.Close()
+}
+
+// state is the content of StateFile.
+type state struct {
+	Version int      `json:"version"`
+	Force   bool     `json:"force"`
+	Files   []string `json:"files,omitempty"`
+}
+
+func (m *Dir) readState() (state, error) {
+	s := state{}
+	data, err := os.ReadFile(filepath.Join(m.path, StateFile))
+	if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, errors.Wrapf(err, "failed to parse %s", StateFile)
+	}
+	return s, nil
+}
+
+func marshalState(s state) ([]byte, error) {
+	return json.MarshalIndent(s, "", "    ")
+}
+
+// Run merges every file of the migration with its current content and replaces all of them in one commit.
+func (m *Dir) Run(migration io.Reader) error {
+	migrData, err := io.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+
+	files := map[string]interface{}{}
+	if err := yaml.Unmarshal(migrData, &files); err != nil {
+		return errors.Wrapf(err, "failed to parse migration file")
+	}
+
+	s, err := m.readState()
+	if err != nil {
+		return err
+	}
+
+	writes := map[string][]byte{}
+	names := make([]string, 0, len(files))
+	for name, content := range files {
+		rel, err := cleanPath(name)
+		if err != nil {
+			return err
+		}
+		if _, ok := writes[rel]; ok {
+			return fmt.Errorf("dir: %s is listed twice", name)
+		}
+
+		data, err := m.migrateFile(rel, content)
+		if err != nil {
+			return errors.Wrapf(err, "failed to migrate %s", rel)
+		}
+		writes[rel] = data
+		names = append(names, rel)
+	}
+	sort.Strings(names)
+
+	var removes []string
+	for _, rel := range s.Files {
+		if _, ok := writes[rel]; !ok {
+			removes = append(removes, rel)
+		}
+	}
+
+	s.Files = names
+	stateData, err := marshalState(s)
+	if err != nil {
+		return err
+	}
+	writes[StateFile] = stateData
+
+	return commit(m.path, writes, removes, m.perm)
+}
+
+// migrateFile merges the migration content of the file rel with its current content and serializes the result.
+func (m *Dir) migrateFile(rel string, content interface{}) ([]byte, error) {
+	driver, err := driverFor(rel)
+	if err != nil {
+		return nil, err
+	}
+
+	migrMap, ok := content.(map[string]interface{})
+	if content != nil && !ok {
+		return nil, fmt.Errorf("dir: content of %s must be a mapping", rel)
+	}
+
+	// Decode the migration the way the file is decoded, so values of the same key have the same type
+	newMap := map[string]interface{}{}
+	if len(migrMap) > 0 {
+		b, err := driver.Marshal(migrMap, false)
+		if err != nil {
+			return nil, err
+		}
+		if err := driver.Unmarshal(b, &newMap); err != nil {
+			return nil, err
+		}
+	}
+
+	fileData, err := os.ReadFile(filepath.Join(m.path, filepath.FromSlash(rel)))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	fileMap := map[string]interface{}{}
+	if len(bytes.TrimSpace(fileData)) > 0 {
+		if err := driver.Unmarshal(fileData, &fileMap); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := driver.Marshal(merger.MergeWithOptions(newMap, fileMap, merger.Options{ArrayMode: m.arrayMergeMode}), m.unableToReplaceComments)
+	if err != nil {
+		return nil, err
+	}
+	return config.CleanOutput(driver, data), nil
+}
+
+// cleanPath returns name as a clean slash-separated path inside the directory.
+func cleanPath(name string) (string, error) {
+	rel := path.Clean(filepath.ToSlash(name))
+	if name == "" || path.IsAbs(rel) || filepath.IsAbs(name) || rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", fmt.Errorf("dir: %q is not a path inside the directory", name)
+	}
+	switch rel {
+	case ".", StateFile, lockFile, journalFile:
+		return "", fmt.Errorf("dir: %q is not a config file", name)
+	}
+	return rel, nil
+}
+
+// SetVersion stores version and dirty in StateFile.
+func (m *Dir) SetVersion(version int, dirty bool) error {
+	s, err := m.readState()
+	if err != nil {
+		return err
+	}
+
+	s.Version, s.Force = version, dirty
+	data, err := marshalState(s)
+	if err != nil {
+		return err
+	}
+
+	return commit(m.path, map[string][]byte{StateFile: data}, nil, m.perm)
+}
+
+// Version returns the version and dirty flag stored in StateFile.
+func (m *Dir) Version() (int, bool, error) {
+	s, err := m.readState()
+	if err != nil {
+		return 0, false, err
+	}
+	if s.Version == 0 {
+		return database.NilVersion, false, nil
+	}
+	return s.Version, s.Force, nil
+}
+
+// Drop removes all managed files and StateFile in one commit.
+func (m *Dir) Drop() error {
+	s, err := m.readState()
+	if err != nil {
+		return err
+	}
+
+	return commit(m.path, nil, append(s.Files, StateFile), m.perm)
+}
@@ -0,0 +1,385 @@
+package dir
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	config "github.com/c2pc/config-migrate/driver"
+	yamlDriver "github.com/c2pc/config-migrate/driver/yaml"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+const configPath = "./examples/config"
+const migrationsPath = "./examples/migrations"
+
+func getConfig() database.Driver {
+	return New(config.Settings{
+		Path:                    configPath,
+		Perm:                    0777,
+		UnableToReplaceComments: true,
+	})
+}
+
+func getSourceURL() string {
+	return fmt.Sprintf("file://%s", migrationsPath)
+}
+
+func readFile(t *testing.T, rel string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(configPath, rel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// listFiles returns the files of the config directory except the lock file.
+func listFiles(t *testing.T) []string {
+	t.Helper()
+	var files []string
+	err := filepath.Walk(configPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() == lockFile {
+			return err
+		}
+		rel, err := filepath.Rel(configPath, path)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestNew(t *testing.T) {
+	var _ database.Driver
+	_ = New(config.Settings{})
+}
+
+func TestOpen(t *testing.T) {
+	dirDriver := New(config.Settings{})
+
+	_, err := dirDriver.Open("1http://foo.com")
+	if err == nil {
+		t.Fatal("expected an error when calling Open with invalid path")
+	}
+
+	_, err = dirDriver.Open("dir://" + configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLock_Unlock(t *testing.T) {
+	defer os.RemoveAll(configPath)
+
+	dirDriver, err := New(config.Settings{}).Open("dir://" + configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dirDriver.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dirDriver.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	v, _, err := dirDriver.Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v != database.NilVersion {
+		t.Errorf("Expected version %d, got: %d", database.NilVersion, v)
+	}
+}
+
+func TestUp1(t *testing.T) {
+	defer os.RemoveAll(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "dir", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	expectedFiles := []string{".migrate.json", "app.ini", "conf.d/db.json", "conf.d/server.yaml"}
+	if files := listFiles(t); !reflect.DeepEqual(files, expectedFiles) {
+		t.Errorf("Expected files %v, got: %v", expectedFiles, files)
+	}
+
+	expected := `
+# HTTP server
+http:
+    host: 0.0.0.0
+    port: 8080`
+	if data := readFile(t, "conf.d/server.yaml"); data != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	expected = `{
+    "dsn": "postgres://localhost/app",
+    "pool": 10
+}`
+	if data := readFile(t, "conf.d/db.json"); data != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	if data := readFile(t, "app.ini"); !strings.Contains(data, "[log]\nlevel = info\n") {
+		t.Errorf("Expected log section in:\n%s", data)
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 1 {
+		t.Errorf("Expected version %d, got: %d", 1, v)
+	}
+
+	if f != false {
+		t.Errorf("Expected force %t, got: %t", false, f)
+	}
+}
+
+func TestUp2(t *testing.T) {
+	defer os.RemoveAll(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "dir", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The pool size is tuned by hand between releases
+	data := strings.Replace(readFile(t, "conf.d/db.json"), `"pool": 10`, `"pool": 15`, 1)
+	if err := os.WriteFile(filepath.Join(configPath, "conf.d/db.json"), []byte(data), 0777); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// app.ini is no longer part of the migration and is removed
+	expectedFiles := []string{".migrate.json", "conf.d/cache.yaml", "conf.d/db.json", "conf.d/server.yaml"}
+	if files := listFiles(t); !reflect.DeepEqual(files, expectedFiles) {
+		t.Errorf("Expected files %v, got: %v", expectedFiles, files)
+	}
+
+	if data := readFile(t, "conf.d/db.json"); !strings.Contains(data, `"pool": 15`) {
+		t.Errorf("Expected the edited pool size in:\n%s", data)
+	}
+
+	if data := readFile(t, "conf.d/server.yaml"); !strings.Contains(data, "tls: false") {
+		t.Errorf("Expected tls in:\n%s", data)
+	}
+
+	if err := m.Steps(-1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	expectedFiles = []string{".migrate.json", "app.ini", "conf.d/db.json", "conf.d/server.yaml"}
+	if files := listFiles(t); !reflect.DeepEqual(files, expectedFiles) {
+		t.Errorf("Expected files %v, got: %v", expectedFiles, files)
+	}
+}
+
+func TestUp3_Invalid_Migration_File(t *testing.T) {
+	defer os.RemoveAll(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "dir", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	before := readFile(t, "conf.d/server.yaml")
+
+	// notes.txt has no driver, so server.yaml must not be changed either
+	if err := m.Steps(1); err == nil {
+		t.Error("expected error")
+		return
+	}
+
+	if after := readFile(t, "conf.d/server.yaml"); after != before {
+		t.Errorf("Expected:\n%s\ngot:\n%s", before, after)
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 3 {
+		t.Errorf("Expected version %d, got: %d", 3, v)
+	}
+
+	if f != true {
+		t.Errorf("Expected force %t, got: %t", true, f)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	defer os.RemoveAll(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "dir", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Files that were never part of a migration are left alone
+	if err := os.WriteFile(filepath.Join(configPath, "local.yaml"), []byte("debug: true\n"), 0777); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Drop(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if files := listFiles(t); !reflect.DeepEqual(files, []string{"local.yaml"}) {
+		t.Errorf("Expected only local.yaml after Drop, got: %v", files)
+	}
+}
+
+// TestUp_SameOutputAsConfig checks that a file is written like the single file driver writes it.
+func TestUp_SameOutputAsConfig(t *testing.T) {
+	tmp := t.TempDir()
+	writeMigration := func(dir, body string) string {
+		migrations := filepath.Join(tmp, dir)
+		if err := os.Mkdir(migrations, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(migrations, "1_config.up.yaml"), []byte(body), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(migrations, "1_config.down.yaml"), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+		return "file://" + filepath.ToSlash(migrations)
+	}
+
+	conf := filepath.Join(tmp, "conf")
+	m, err := migrate.NewWithDatabaseInstance(writeMigration("dir", "app.yaml:\n  name: \"O'Brien\"\n  log: /dev/null\n"), "dir", New(config.Settings{Path: conf}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	single := filepath.Join(tmp, "app.yaml")
+	d := yamlDriver.New(config.Settings{Path: single, VersionFile: single + ".migrate.json"})
+	m, err = migrate.NewWithDatabaseInstance(writeMigration("single", "name: \"O'Brien\"\nlog: /dev/null\n"), "yaml", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(conf, "app.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := os.ReadFile(single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(expected) {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestLock_RecoverCommit(t *testing.T) {
+	defer os.RemoveAll(configPath)
+
+	if err := os.MkdirAll(filepath.Join(configPath, "conf.d"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"conf.d/a.yaml": "a: old\n",
+		"conf.d/b.yaml": "b: old\n",
+	} {
+		if err := os.WriteFile(filepath.Join(configPath, name), []byte(data), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A crash after the journal was written and a.yaml was already replaced
+	writes := map[string][]byte{"conf.d/a.yaml": []byte("a: new\n"), "conf.d/b.yaml": []byte("b: new\n")}
+	j := journal{Writes: map[string]string{}, Removes: []string{"conf.d/gone.yaml"}}
+	for rel, data := range writes {
+		tmp, err := writeTemp(configPath, rel, data, 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		j.Writes[rel] = tmp
+	}
+	if err := os.Rename(filepath.Join(configPath, j.Writes["conf.d/a.yaml"]), filepath.Join(configPath, "conf.d/a.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeJournal(configPath, data); err != nil {
+		t.Fatal(err)
+	}
+
+	d := getConfig()
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	for rel, expected := range writes {
+		if data := readFile(t, rel); data != string(expected) {
+			t.Errorf("Expected %s to be %q, got: %q", rel, expected, data)
+		}
+	}
+
+	if files := listFiles(t); !reflect.DeepEqual(files, []string{"conf.d/a.yaml", "conf.d/b.yaml"}) {
+		t.Errorf("Expected the journal and temp files to be gone, got: %v", files)
+	}
+}
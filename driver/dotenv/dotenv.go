@@ -0,0 +1,308 @@
+package dotenv
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/c2pc/config-migrate/driver"
+	"github.com/golang-migrate/migrate/v4/database"
+)
+
+// DefaultSeparator joins the keys of a nested path in variable names: "db.max_open_conn" → "DB_MAX_OPEN_CONN".
+const DefaultSeparator = "_"
+
+// Dotenv implements driver.Driver for KEY=value environment files.
+// Variables become flat string keys. Dotted keys from migrations and nested maps are flattened to upper-snake names
+// joined with Separator; other keys are kept as written, so version and force are stored as "version" and "force".
+type Dotenv struct {
+	// Separator — joins the keys of a nested path in variable names; DefaultSeparator if empty.
+	Separator string
+
+	// Export — if true, every variable is written with an "export " prefix so the file can be sourced by a shell.
+	Export bool
+}
+
+func init() {
+	config.Register("dotenv", &Dotenv{}, config.Settings{})
+}
+
+// New returns a database.Driver that uses the dotenv driver with the given settings. Use config.New with a Dotenv to
+// set Separator and Export, or the x-separator and x-export query parameters of the URL.
+func New(cfg config.Settings) database.Driver {
+	return config.New(&Dotenv{}, cfg)
+}
+
+// Configure returns a copy of d with Separator and Export set from the x-separator and x-export query parameters.
+func (d Dotenv) Configure(query url.Values) (config.Driver, error) {
+	if query.Has("x-separator") {
+		d.Separator = query.Get("x-separator")
+	}
+	if query.Has("x-export") {
+		export, err := strconv.ParseBool(query.Get("x-export"))
+		if err != nil {
+			return nil, fmt.Errorf("x-export: %w", err)
+		}
+		d.Export = export
+	}
+	return &d, nil
+}
+
+// Unmarshal parses KEY=value lines into a flat map of strings. Blank lines and '#' comments are skipped, an "export "
+// prefix is ignored, values may be single-quoted (literal), double-quoted (with \n, \t, \" and \\ escapes, possibly
+// spanning lines) or unquoted (a " #" starts a comment).
+func (d Dotenv) Unmarshal(data []byte, out interface{}) error {
+	ptr, ok := out.(*map[string]interface{})
+	if !ok {
+		return fmt.Errorf("dotenv: out must be *map[string]interface{}")
+	}
+
+	m := map[string]interface{}{}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest := strings.TrimPrefix(line, "export"); rest != line && (strings.HasPrefix(rest, " ") || strings.HasPrefix(rest, "\t")) {
+			line = strings.TrimSpace(rest)
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return fmt.Errorf("dotenv: line %d: expected KEY=value", i+1)
+		}
+		key := strings.TrimSpace(line[:eq])
+		value := strings.TrimLeft(line[eq+1:], " \t")
+
+		start := i
+		var err error
+		if value, i, err = parseValue(value, lines, i); err != nil {
+			return fmt.Errorf("dotenv: line %d: %w", start+1, err)
+		}
+
+		if strings.Contains(key, ".") {
+			key = d.envName(strings.Split(key, "."))
+		}
+		m[key] = value
+	}
+
+	*ptr = m
+	return nil
+}
+
+// parseValue parses the value starting on line i and returns it with the index of its last line.
+func parseValue(value string, lines []string, i int) (string, int, error) {
+	if value == "" {
+		return "", i, nil
+	}
+
+	quote := value[0]
+	if quote != '"' && quote != '\'' {
+		if j := strings.Index(value, " #"); j >= 0 {
+			value = value[:j]
+		}
+		if j := strings.Index(value, "\t#"); j >= 0 {
+			value = value[:j]
+		}
+		return strings.TrimSpace(value), i, nil
+	}
+
+	var b strings.Builder
+	s := value[1:]
+	for {
+		for j := 0; j < len(s); j++ {
+			c := s[j]
+			switch {
+			case c == quote:
+				return b.String(), i, nil
+			case c == '\\' && quote == '"' && j+1 < len(s):
+				j++
+				switch s[j] {
+				case 'n':
+					b.WriteByte('\n')
+				case 'r':
+					b.WriteByte('\r')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(s[j])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+
+		// The quoted value continues on the next line
+		i++
+		if i >= len(lines) {
+			return "", i, fmt.Errorf("unterminated %c quote", quote)
+		}
+		b.WriteByte('\n')
+		s = lines[i]
+	}
+}
+
+// Marshal serializes the map to KEY=value lines sorted by name. Nested maps are flattened to upper-snake names.
+// If replaceComments is true, keys ending with config.CommentSuffix are written as '#' comments above the variable
+// they belong to; an empty comment becomes a blank line.
+func (d Dotenv) Marshal(i interface{}, replaceComments bool) ([]byte, error) {
+	m, ok := i.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("dotenv: expected map[string]interface{}")
+	}
+
+	vars := map[string]interface{}{}
+	d.flatten(vars, nil, m)
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		if replaceComments && isCommentKey(name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	prefix := ""
+	if d.Export {
+		prefix = "export "
+	}
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		if replaceComments {
+			for _, comment := range commentsFor(vars, name) {
+				if comment == "" {
+					if buf.Len() > 0 {
+						buf.WriteString("\n")
+					}
+					continue
+				}
+				for _, line := range strings.Split(comment, "\n") {
+					buf.WriteString("# " + line + "\n")
+				}
+			}
+		}
+		buf.WriteString(prefix + name + "=" + formatValue(vars[name]) + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// flatten stores the values of m in vars; keys of nested maps are joined into upper-snake names.
+func (d Dotenv) flatten(vars map[string]interface{}, path []string, m map[string]interface{}) {
+	for k, v := range m {
+		p := append(append([]string(nil), path...), k)
+		if child, ok := v.(map[string]interface{}); ok {
+			d.flatten(vars, p, child)
+			continue
+		}
+		name := k
+		if len(p) > 1 || strings.Contains(k, ".") {
+			name = d.envName(p)
+		}
+		vars[name] = v
+	}
+}
+
+// envName converts a key path to a variable name: ["db", "max_open_conn"] → "DB_MAX_OPEN_CONN".
+// Merger directive suffixes such as "_deprecated_replace" are kept as written.
+func (d Dotenv) envName(path []string) string {
+	key := strings.Join(path, ".")
+	suffix := ""
+	if i := strings.Index(key, "_deprecated"); i >= 0 {
+		key, suffix = key[:i], key[i:]
+	}
+
+	sep := d.Separator
+	if sep == "" {
+		sep = DefaultSeparator
+	}
+	return strings.ToUpper(strings.ReplaceAll(key, ".", sep)) + suffix
+}
+
+// formatValue formats a value, quoting it if it would not be read back unchanged or contains spaces: double quotes
+// with escapes. '$' is not escaped, so references such as ${HOST} are interpolated as before.
+func formatValue(v interface{}) string {
+	var s string
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		s = t
+	case []interface{}:
+		parts := make([]string, len(t))
+		for i, e := range t {
+			parts[i] = fmt.Sprint(e)
+		}
+		s = strings.Join(parts, ",")
+	default:
+		s = fmt.Sprint(t)
+	}
+
+	if !strings.ContainsAny(s, " \t#'\"\\\n\r") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`).Replace(s) + `"`
+}
+
+func isCommentKey(k string) bool {
+	return strings.HasSuffix(k, config.CommentSuffix) && commentTarget(k) != ""
+}
+
+// commentTarget returns the variable a comment key belongs to: "HOST______" and "HOST_______" both belong to "HOST".
+func commentTarget(k string) string {
+	return strings.TrimRight(strings.TrimSuffix(k, config.CommentSuffix), "_")
+}
+
+// commentsFor returns the comments for name in vars, ordered by comment key.
+func commentsFor(vars map[string]interface{}, name string) []string {
+	var keys []string
+	for k := range vars {
+		if isCommentKey(k) && commentTarget(k) == name {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	comments := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if vars[k] == nil {
+			comments = append(comments, "")
+			continue
+		}
+		comments = append(comments, strings.TrimSpace(fmt.Sprint(vars[k])))
+	}
+	return comments
+}
+
+// Version reads version and force (all values are stored as strings).
+func (d Dotenv) Version(data []byte) (int, bool, error) {
+	var out map[string]interface{}
+	if err := d.Unmarshal(data, &out); err != nil {
+		return 0, false, err
+	}
+
+	version := 0
+	if s, ok := out["version"].(string); ok {
+		version, _ = strconv.Atoi(s)
+	}
+	force := false
+	if s, ok := out["force"].(string); ok {
+		force, _ = strconv.ParseBool(s)
+	}
+	return version, force, nil
+}
+
+// EmptyData returns an empty environment file.
+func (Dotenv) EmptyData() []byte {
+	return []byte{}
+}
+
+// Verbatim reports that Marshal output is written as is: quotes and "null" are part of the values.
+func (Dotenv) Verbatim() bool {
+	return true
+}
@@ -0,0 +1,441 @@
+package dotenv
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	config "github.com/c2pc/config-migrate/driver"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+const configPath = "./examples/config.env"
+const migrationsPath = "./examples/migrations"
+
+func getConfig() database.Driver {
+	return New(config.Settings{
+		Path:                    configPath,
+		Perm:                    0777,
+		UnableToReplaceComments: true,
+	})
+}
+
+func getSourceURL() string {
+	return fmt.Sprintf("file://%s", migrationsPath)
+}
+
+func readConfigFile(path string) (map[string]interface{}, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, config.DefaultPerm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fileData, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	fileMap := map[string]interface{}{}
+	if err := (Dotenv{}).Unmarshal(fileData, &fileMap); err != nil {
+		return nil, err
+	}
+
+	return fileMap, nil
+}
+
+func TestNew(t *testing.T) {
+	var _ database.Driver
+	_ = New(config.Settings{})
+}
+
+func TestOpen(t *testing.T) {
+	dotenvDriver := New(config.Settings{})
+
+	_, err := dotenvDriver.Open("1http://foo.com")
+	if err == nil {
+		t.Fatal("expected an error when calling Open with invalid path")
+	}
+
+	_, err = dotenvDriver.Open(getSourceURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLock_Unlock(t *testing.T) {
+	defer os.Remove(configPath)
+
+	dotenvDriver := New(config.Settings{})
+
+	_, err := dotenvDriver.Open("dotenv://" + configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dotenvDriver.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dotenvDriver.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readConfigFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUp1(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "dotenv", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := "# Database connection string\n" +
+		"DB_DSN=postgres://localhost/app?sslmode=disable\n" +
+		"DB_MAX_OPEN_CONN=10\n" +
+		"boolean=true\n" +
+		"force=false\n" +
+		"number=1\n" +
+		"\n" +
+		"# Str comment\n" +
+		"str=str\n" +
+		"version=1\n"
+
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 1 {
+		t.Errorf("Expected version %d, got: %d", 1, v)
+	}
+
+	if f != false {
+		t.Errorf("Expected force %t, got: %t", false, f)
+	}
+}
+
+func TestUp3(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "dotenv", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(3); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// DB_MAX_OPEN_CONN keeps 10 from migration 1 and is replaced with 50 by migration 3
+	expected := map[string]interface{}{
+		"version": "3", "force": "false", "str": "str", "number": "1", "boolean": "true",
+		"DB_DSN":           "postgres://localhost/app?sslmode=disable",
+		"DB_MAX_OPEN_CONN": "50",
+		"DB_MAX_IDLE_CONN": "5",
+		"greeting":         "hello world",
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, result)
+	}
+
+	if err := m.Steps(-1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err = readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected["version"] = "2"
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, result)
+	}
+}
+
+func TestUp4_Invalid_Migration_File(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "dotenv", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(4); err == nil {
+		t.Error("expected error")
+		return
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 4 {
+		t.Errorf("Expected version %d, got: %d", 4, v)
+	}
+
+	if f != true {
+		t.Errorf("Expected force %t, got: %t", true, f)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "dotenv", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Drop(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(result) != 0 {
+		t.Errorf("Expected empty config after Drop, got: %v", result)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	data := "# comment\n" +
+		"export PLAIN = value # trailing comment\n" +
+		"SINGLE='literal \\n $HOME'\n" +
+		"DOUBLE=\"tab\\there \\\"quoted\\\"\"\n" +
+		"MULTI=\"line 1\n" +
+		"line 2\"\n" +
+		"EMPTY=\n" +
+		"HASH=a#b\n" +
+		"db.max_open_conn=10\n"
+
+	out := map[string]interface{}{}
+	if err := (Dotenv{}).Unmarshal([]byte(data), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"PLAIN":            "value",
+		"SINGLE":           "literal \\n $HOME",
+		"DOUBLE":           "tab\there \"quoted\"",
+		"MULTI":            "line 1\nline 2",
+		"EMPTY":            "",
+		"HASH":             "a#b",
+		"DB_MAX_OPEN_CONN": "10",
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, out)
+	}
+
+	if err := (Dotenv{}).Unmarshal([]byte("KEY=\"unterminated\n"), &out); err == nil {
+		t.Error("expected error for unterminated quote")
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	in := map[string]interface{}{
+		"db": map[string]interface{}{
+			"max_open_conn": 10,
+			"pool":          map[string]interface{}{"size": 5},
+		},
+		"greeting": "hello world",
+		"quote":    "it's \"here\"",
+		"hosts":    []interface{}{"a", "b"},
+		"skipped":  nil,
+	}
+
+	b, err := (Dotenv{Separator: "__", Export: true}).Marshal(in, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "export DB__MAX_OPEN_CONN=10\n" +
+		"export DB__POOL__SIZE=5\n" +
+		"export greeting=\"hello world\"\n" +
+		"export hosts=a,b\n" +
+		"export quote=\"it's \\\"here\\\"\"\n" +
+		"export skipped=\n"
+	if string(b) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, b)
+	}
+
+	out := map[string]interface{}{}
+	if err := (Dotenv{}).Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out["quote"] != in["quote"] || out["greeting"] != in["greeting"] {
+		t.Errorf("Expected values to round trip, got: %v", out)
+	}
+}
+
+func TestUp_KeepsQuotedValues(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.env")
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.up.env"), []byte("A=a\nURL=http://localhost\nNAME=\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.down.env"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	current := "A='a #b'\n" +
+		"NAME=\"it's null\"\n" +
+		"URL=http://${HOST}\n"
+	if err := os.WriteFile(path, []byte(current), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := migrate.NewWithDatabaseInstance("file://"+filepath.ToSlash(migrations), "dotenv", New(config.Settings{Path: path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "A=\"a #b\"\n" +
+		"NAME=\"it's null\"\n" +
+		"URL=http://${HOST}\n" +
+		"force=false\n" +
+		"version=1\n"
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
+
+func TestUp_QueryOptions(t *testing.T) {
+	dir := t.TempDir()
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.up.env"), []byte("db.max_open_conn=10\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.down.env"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{
+			name:     "separator and export",
+			query:    "?x-separator=__&x-export=true",
+			expected: "export DB__MAX_OPEN_CONN=10\nexport force=false\nexport version=1\n",
+		},
+		{
+			name:     "defaults",
+			expected: "DB_MAX_OPEN_CONN=10\nforce=false\nversion=1\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.env")
+			m, err := migrate.New("file://"+filepath.ToSlash(migrations), "dotenv://"+path+tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Up(); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.expected {
+				t.Errorf("Expected:\n%s\ngot:\n%s", tt.expected, data)
+			}
+		})
+	}
+
+	if _, err := migrate.New("file://"+filepath.ToSlash(migrations), "dotenv://"+filepath.Join(dir, "config.env")+"?x-export=maybe"); err == nil {
+		t.Error("expected an error for an invalid x-export")
+	}
+}
+
+func TestMarshal_KeepsCommentKeys(t *testing.T) {
+	in := map[string]interface{}{
+		"PORT" + config.CommentSuffix: "The port",
+		"PORT":                        "8080",
+	}
+
+	b, err := (Dotenv{}).Marshal(in, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := map[string]interface{}{}
+	if err := (Dotenv{}).Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Expected: %v, got: %v", in, out)
+	}
+}
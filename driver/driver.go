@@ -2,7 +2,11 @@ package config
 
 import (
 	"io/fs"
+	nurl "net/url"
+	"strings"
+	"time"
 
+	"github.com/c2pc/config-migrate/merger"
 	"github.com/golang-migrate/migrate/v4/database"
 )
 
@@ -30,6 +34,70 @@ type Settings struct {
 	// BackupBeforeMigrate if true, backs up the config file once per migration run (current version) before applying any migration.
 	// E.g. when migrating 2→10, only one backup is made (state at version 2), not before each of 3,4,…,10.
 	BackupBeforeMigrate bool
+
+	// BackupDir — directory the backups are written to; defaults to the directory of the config file.
+	// Backups are named like "config.yaml.v2.20260102T150405.000000000Z.backup".
+	BackupDir string
+
+	// BackupKeep — number of the newest backups to keep; older ones are removed after each backup. 0 keeps all.
+	BackupKeep int
+
+	// BackupMaxAge — backups older than this are removed after each backup. 0 keeps them regardless of age.
+	BackupMaxAge time.Duration
+
+	// VersionFile — path to a JSON sidecar file (e.g. "config.yaml.migrate.json") that stores version and force
+	// instead of the config file itself, so the config contains only application keys.
+	VersionFile string
+
+	// VersionKey — dot-separated key path the version is stored under in the config file (default "version"),
+	// e.g. "_meta.schema_version". Ignored if VersionFile is set.
+	VersionKey string
+
+	// ForceKey — dot-separated key path of the dirty flag. Defaults to "force" next to VersionKey, e.g. "_meta.force".
+	ForceKey string
+
+	// HistoryFile — path to a JSONL file (e.g. "config.yaml.history.jsonl") every applied migration is appended to
+	// with its version, direction, time, hostname and checksums of the migration and the resulting config.
+	HistoryFile string
+
+	// Schema — JSON Schema the merged config is validated against after every migration. If the config does not match,
+	// Run returns a *SchemaError and the config and its version are restored to the state before the migration.
+	// Version keys and comment keys are not validated; values of INI configs are strings.
+	Schema []byte
+
+	// SchemaFile — path to the JSON Schema file, used if Schema is empty.
+	SchemaFile string
+
+	// DryRun if true, migrations are merged as usual but nothing is written: the would-be config and version
+	// are kept in memory and can be inspected with Config.Plan.
+	DryRun bool
+
+	// OnDiff if set, is called with the changed key paths and a unified diff of the config for every applied migration.
+	OnDiff func(Diff)
+
+	// OnExplain if set, is called with where every value of the config came from for every applied migration.
+	OnExplain func(Explanation)
+
+	// InPlaceFallback if true, the config is rewritten in place, which is not atomic, when the new file cannot be given
+	// the owner and group of the config, e.g. when the migration does not run as root. Otherwise writing fails.
+	InPlaceFallback bool
+
+	// PreserveFormatting if true and the driver implements Patcher, the merged config is written onto the existing
+	// document instead of being serialized from scratch, so key order, comments and blank lines of untouched keys are kept.
+	PreserveFormatting bool
+
+	// ArrayMergeMode — how arrays of a migration are combined with the arrays of the config unless a key sets its own
+	// mode with a _merge_mode directive: merger.ArrayMerge (default), ArrayReplace, ArrayAppend, ArrayUnion or ArrayKeep.
+	ArrayMergeMode merger.ArrayMode
+
+	// StrictMerge if true, Run fails with merger.DirectiveErrors when a directive of the migration cannot be applied,
+	// e.g. a _deprecated path that is not in the config or a spec with a typo, and the config and its version are
+	// restored to the state before the migration.
+	StrictMerge bool
+
+	// OnMergeWarning if set and StrictMerge is false, is called with the directives of a migration that could not be
+	// applied; the migration is applied anyway.
+	OnMergeWarning func(merger.DirectiveErrors)
 }
 
 // Driver is the interface that every config driver must implement.
@@ -47,6 +115,51 @@ type Driver interface {
 	EmptyData() []byte
 }
 
+// Patcher is an optional interface a Driver can implement to support Settings.PreserveFormatting.
+type Patcher interface {
+	// Patch — applies a map onto the original document and returns the updated document.
+	Patch([]byte, interface{}, bool) ([]byte, error)
+}
+
+// Aligner is an optional interface a Driver can implement when a file can be read into maps of different shapes, such
+// as a single YAML document and a stream of several.
+type Aligner interface {
+	// Align — returns the data of the file reshaped like the data of the migration.
+	Align(migration, current map[string]interface{}) map[string]interface{}
+}
+
+// Verbatim is an optional interface a Driver can implement to have its Marshal output written unchanged. Without it,
+// single quotes and "null" are removed from the output (see CleanOutput).
+type Verbatim interface {
+	// Verbatim — reports whether the output of Marshal must be written unchanged.
+	Verbatim() bool
+}
+
+// IsVerbatim reports whether the output of d.Marshal must be written unchanged.
+func IsVerbatim(d Driver) bool {
+	v, ok := d.(Verbatim)
+	return ok && v.Verbatim()
+}
+
+// CleanOutput returns the output of d.Marshal as it is written to the config file: single quotes and "null" are
+// removed unless d is Verbatim.
+func CleanOutput(d Driver, data []byte) []byte {
+	if IsVerbatim(d) {
+		return data
+	}
+
+	out := strings.ReplaceAll(string(data), "'", "")
+	out = strings.ReplaceAll(out, "null", "")
+	return []byte(out)
+}
+
+// Configurable is an optional interface a Driver can implement to read options of its own from the query parameters of
+// the URL passed to Open, e.g. the separator of dotenv names.
+type Configurable interface {
+	// Configure — returns a copy of the driver configured from the query parameters.
+	Configure(nurl.Values) (Driver, error)
+}
+
 // Open returns a new instance of a migration database driver using the given URL.
 func Open(url string) (database.Driver, error) {
 	return database.Open(url)
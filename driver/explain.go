@@ -0,0 +1,34 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/c2pc/config-migrate/merger"
+)
+
+// Explanation describes where every value of the config came from after one migration.
+type Explanation struct {
+	// Version — the version the migration leads to.
+	Version int
+
+	// Trace — the origin of every leaf key path sorted by path. Version keys and comment keys are not included.
+	Trace merger.Trace
+}
+
+// SetExplainHandler sets the function called with the Explanation of every migration applied by Run, like
+// Settings.OnExplain.
+func (m *Config) SetExplainHandler(fn func(Explanation)) {
+	m.onExplain = fn
+}
+
+// reportExplanation calls the explain handler with trace without comment keys.
+func (m *Config) reportExplanation(trace merger.Trace) {
+	entries := make(merger.Trace, 0, len(trace))
+	for _, e := range trace {
+		if !strings.Contains(e.Path, CommentSuffix) {
+			entries = append(entries, e)
+		}
+	}
+
+	m.onExplain(Explanation{Version: m.target, Trace: entries})
+}
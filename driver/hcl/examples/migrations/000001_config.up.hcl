@@ -0,0 +1,24 @@
+version = 1
+force   = false
+
+datacenter______ = ""
+datacenter_______ = "Datacenter the agent runs in"
+datacenter = "dc1"
+data_dir   = "/opt/nomad/data"
+
+client {
+  enabled = true
+  servers = ["10.0.0.1:4647", "10.0.0.2:4647"]
+
+  meta = {
+    rack = "r1"
+  }
+}
+
+plugin______ = ""
+plugin_______ = "Task drivers"
+plugin "docker" {
+  config {
+    allow_privileged = false
+  }
+}
@@ -0,0 +1,42 @@
+version = 2
+force   = false
+
+datacenter______ = ""
+datacenter_______ = "Datacenter the agent runs in"
+datacenter = "dc1"
+data_dir   = "/opt/nomad/data"
+bind_addr  = "{{ GetPrivateIP }}"
+
+client {
+  enabled = true
+  servers = ["10.0.0.1:4647", "10.0.0.2:4647"]
+
+  meta = {
+    rack = "r1"
+  }
+
+  host_volume "certs" {
+    path      = "/etc/ssl/certs"
+    read_only = true
+  }
+}
+
+plugin______ = ""
+plugin_______ = "Task drivers"
+plugin "docker" {
+  config {
+    allow_privileged_deprecated_replace = ""
+    allow_privileged = true
+  }
+}
+
+plugin "raw_exec" {
+  config {
+    enabled = true
+  }
+}
+
+telemetry {
+  publish_allocation_metrics = true
+  prometheus_metrics         = true
+}
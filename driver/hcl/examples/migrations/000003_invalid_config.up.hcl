@@ -0,0 +1 @@
+invalid config file up {
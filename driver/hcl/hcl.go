@@ -0,0 +1,486 @@
+package hcl
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/c2pc/config-migrate/driver"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Expression is the source of an attribute value that is not a literal, like a reference, a function call or
+// a template with interpolations. It is read and written back unchanged, and merged like a string.
+type Expression string
+
+// shapesKey holds how the blocks and object attributes of the data were written, e.g. "job:1,job.group:1,meta:attr".
+// It ends with config.CommentSuffix so that diffs and schema validation skip it like a comment.
+const shapesKey = "#shapes______"
+
+// Hcl implements driver.Driver for HCL2 config files like Nomad and Vault agent configs using
+// github.com/hashicorp/hcl/v2. Attributes become keys and blocks become nested maps:
+//   - a block without labels is a map: client { enabled = true } → "client": {"enabled": true};
+//   - the labels of a block are nested keys: job "web" { group "app" {} } → "job": {"web": {"group": {"app": {}}}};
+//   - repeated blocks of the same type and labels are an array: template {} template {} → "template": [{}, {}].
+//
+// HCL does not tell a block from an object attribute without a schema, so Unmarshal stores how every block type and
+// attribute was written with the data, and Marshal writes them back the same way. The shapes of the live config win
+// over the shapes of a migration. Maps without a shape are written as blocks without labels.
+type Hcl struct{}
+
+// shapes maps key paths with labels omitted, e.g. "job.group.task", to how they were written.
+type shapes map[string]shape
+
+// shape is how a key was written in HCL.
+type shape struct {
+	attribute bool // An attribute whose value is an object or a list of objects
+	labels    int  // Number of labels of a block
+}
+
+func init() {
+	config.Register("hcl", &Hcl{}, config.Settings{})
+}
+
+// New returns a database.Driver that uses the HCL driver with the given settings.
+func New(cfg config.Settings) database.Driver {
+	return config.New(&Hcl{}, cfg)
+}
+
+// Unmarshal parses HCL native syntax into a map. Literal values are decoded to string, int, float64, bool,
+// []interface{} and map[string]interface{}; other expressions are kept as Expression.
+func (h *Hcl) Unmarshal(data []byte, out interface{}) error {
+	ptr, ok := out.(*map[string]interface{})
+	if !ok {
+		return fmt.Errorf("hcl: out must be *map[string]interface{}")
+	}
+
+	file, diags := hclsyntax.ParseConfig(data, "config.hcl", hcl.InitialPos)
+	if diags.HasErrors() {
+		return fmt.Errorf("hcl: %s", diags.Error())
+	}
+
+	sh := shapes{}
+	m, err := h.decodeBody(data, file.Body.(*hclsyntax.Body), "", sh)
+	if err != nil {
+		return err
+	}
+	if len(sh) > 0 {
+		m[shapesKey] = sh.String()
+	}
+	*ptr = m
+	return nil
+}
+
+// decodeBody decodes the attributes and blocks of body; path is the key path of body with labels omitted.
+func (h *Hcl) decodeBody(src []byte, body *hclsyntax.Body, path string, sh shapes) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+
+	for name, attr := range body.Attributes {
+		v, err := decodeExpr(src, attr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		if isObjects(v) {
+			sh[path+name] = shape{attribute: true}
+		}
+		m[name] = v
+	}
+
+	for _, block := range body.Blocks {
+		if _, ok := body.Attributes[block.Type]; ok {
+			return nil, fmt.Errorf("hcl: %s: %q is both an attribute and a block", block.DefRange(), block.Type)
+		}
+
+		blockPath := path + block.Type
+		sh[blockPath] = shape{labels: len(block.Labels)}
+
+		v, err := h.decodeBody(src, block.Body, blockPath+".", sh)
+		if err != nil {
+			return nil, err
+		}
+
+		// Walk down the labels to the map the block is stored in
+		parent, key := m, block.Type
+		for _, label := range block.Labels {
+			child, ok := parent[key].(map[string]interface{})
+			if !ok {
+				if parent[key] != nil {
+					return nil, fmt.Errorf("hcl: %s: block %q has a different number of labels", block.DefRange(), block.Type)
+				}
+				child = map[string]interface{}{}
+				parent[key] = child
+			}
+			parent, key = child, label
+		}
+
+		switch prev := parent[key].(type) {
+		case nil:
+			parent[key] = v
+		case []interface{}:
+			parent[key] = append(prev, v)
+		default:
+			parent[key] = []interface{}{prev, v}
+		}
+	}
+
+	return m, nil
+}
+
+// String encodes the shapes for shapesKey, sorted by path.
+func (sh shapes) String() string {
+	parts := make([]string, 0, len(sh))
+	for path, s := range sh {
+		if s.attribute {
+			parts = append(parts, path+":attr")
+		} else {
+			parts = append(parts, path+":"+strconv.Itoa(s.labels))
+		}
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// parseShapes decodes the value of shapesKey; unknown entries are skipped.
+func parseShapes(v interface{}) shapes {
+	sh := shapes{}
+	str, _ := v.(string)
+	for _, part := range strings.Split(str, ",") {
+		i := strings.LastIndex(part, ":")
+		if i < 0 {
+			continue
+		}
+		path, kind := part[:i], part[i+1:]
+		if kind == "attr" {
+			sh[path] = shape{attribute: true}
+		} else if n, err := strconv.Atoi(kind); err == nil {
+			sh[path] = shape{labels: n}
+		}
+	}
+	return sh
+}
+
+// Align adds the shapes of the migration to the shapes of the live config, so that blocks added by the migration
+// keep their labels.
+func (h *Hcl) Align(migration, current map[string]interface{}) map[string]interface{} {
+	if _, ok := migration[shapesKey]; !ok {
+		return current
+	}
+	sh := parseShapes(migration[shapesKey])
+	for path, s := range parseShapes(current[shapesKey]) {
+		sh[path] = s
+	}
+	current[shapesKey] = sh.String()
+	return current
+}
+
+// decodeExpr evaluates a literal expression or returns the source of any other expression as Expression.
+func decodeExpr(src []byte, expr hclsyntax.Expression) (interface{}, error) {
+	if len(expr.Variables()) == 0 {
+		if val, diags := expr.Value(nil); !diags.HasErrors() {
+			return fromCty(val), nil
+		}
+	}
+	r := expr.Range()
+	return Expression(src[r.Start.Byte:r.End.Byte]), nil
+}
+
+// fromCty converts a cty value to a Go value; whole numbers become int.
+func fromCty(val cty.Value) interface{} {
+	if val.IsNull() || !val.IsKnown() {
+		return nil
+	}
+
+	t := val.Type()
+	switch {
+	case t == cty.String:
+		return val.AsString()
+	case t == cty.Bool:
+		return val.True()
+	case t == cty.Number:
+		bf := val.AsBigFloat()
+		if bf.IsInt() {
+			if i, acc := bf.Int64(); acc == big.Exact {
+				return int(i)
+			}
+		}
+		f, _ := bf.Float64()
+		return f
+	case t.IsTupleType() || t.IsListType() || t.IsSetType():
+		out := make([]interface{}, 0, val.LengthInt())
+		for it := val.ElementIterator(); it.Next(); {
+			_, v := it.Element()
+			out = append(out, fromCty(v))
+		}
+		return out
+	case t.IsObjectType() || t.IsMapType():
+		out := map[string]interface{}{}
+		for it := val.ElementIterator(); it.Next(); {
+			k, v := it.Element()
+			out[k.AsString()] = fromCty(v)
+		}
+		return out
+	}
+	return nil
+}
+
+// isObjects reports whether v is an object or a non-empty list of objects, which could also be written as blocks.
+func isObjects(v interface{}) bool {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return true
+	case []interface{}:
+		for _, e := range t {
+			if _, ok := e.(map[string]interface{}); !ok {
+				return false
+			}
+		}
+		return len(t) > 0
+	}
+	return false
+}
+
+// Marshal serializes the map to HCL. Attributes of a body come first, followed by its blocks, each sorted by key.
+// If replaceComments is true, keys ending with config.CommentSuffix are written as '#' comments above the attribute
+// or block they belong to; an empty comment becomes a blank line.
+func (h *Hcl) Marshal(i interface{}, replaceComments bool) ([]byte, error) {
+	m, ok := i.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("hcl: expected map[string]interface{}")
+	}
+
+	f := hclwrite.NewEmptyFile()
+	if err := h.encodeBody(f.Body(), m, "", parseShapes(m[shapesKey]), replaceComments); err != nil {
+		return nil, err
+	}
+	return hclwrite.Format(f.Bytes()), nil
+}
+
+// encodeBody writes m to body; path is the key path of body with labels omitted.
+func (h *Hcl) encodeBody(body *hclwrite.Body, m map[string]interface{}, path string, sh shapes, replaceComments bool) error {
+	var attrs, blocks []string
+	for k, v := range m {
+		switch {
+		case path == "" && k == shapesKey:
+		case replaceComments && isCommentKey(k):
+		case v == nil:
+			// HCL has no way to leave an attribute empty, and a null one is an unset one
+		case isObjects(v) && !sh[path+k].attribute:
+			blocks = append(blocks, k)
+		default:
+			attrs = append(attrs, k)
+		}
+	}
+	sort.Strings(attrs)
+	sort.Strings(blocks)
+
+	first := true
+	for _, k := range attrs {
+		if replaceComments {
+			writeComments(body, m, k, first)
+		}
+		first = false
+
+		if !hclsyntax.ValidIdentifier(k) {
+			return fmt.Errorf("hcl: %q is not a valid attribute name", k)
+		}
+		if expr, ok := m[k].(Expression); ok {
+			tokens, err := exprTokens(expr)
+			if err != nil {
+				return err
+			}
+			body.SetAttributeRaw(k, tokens)
+			continue
+		}
+		val, err := toCty(m[k])
+		if err != nil {
+			return fmt.Errorf("hcl: %s: %w", k, err)
+		}
+		body.SetAttributeValue(k, val)
+	}
+
+	for _, k := range blocks {
+		if replaceComments {
+			writeComments(body, m, k, first)
+		}
+		if !first && (!replaceComments || len(commentsFor(m, k)) == 0) {
+			body.AppendNewline()
+		}
+		first = false
+
+		if !hclsyntax.ValidIdentifier(k) {
+			return fmt.Errorf("hcl: %q is not a valid block type", k)
+		}
+		if err := h.encodeBlocks(body, k, nil, m[k], sh[path+k].labels, path+k, sh, replaceComments); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeBlocks writes v as blocks of type typ; the first labels levels of nested maps in v are the labels.
+func (h *Hcl) encodeBlocks(body *hclwrite.Body, typ string, labels []string, v interface{}, n int, path string, sh shapes, replaceComments bool) error {
+	if len(labels) < n {
+		if child, ok := v.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(child))
+			for k := range child {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+
+			for i, k := range keys {
+				if i > 0 {
+					body.AppendNewline()
+				}
+				if err := h.encodeBlocks(body, typ, append(append([]string(nil), labels...), k), child[k], n, path, sh, replaceComments); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	switch t := v.(type) {
+	case []interface{}:
+		for i, e := range t {
+			if i > 0 {
+				body.AppendNewline()
+			}
+			if err := h.encodeBlocks(body, typ, labels, e, len(labels), path, sh, replaceComments); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		block := body.AppendNewBlock(typ, labels)
+		return h.encodeBody(block.Body(), t, path+".", sh, replaceComments)
+	}
+	return fmt.Errorf("hcl: block %s %q must be an object", typ, labels)
+}
+
+// exprTokens returns the tokens of the expression source.
+func exprTokens(expr Expression) (hclwrite.Tokens, error) {
+	f, diags := hclwrite.ParseConfig([]byte("x = "+string(expr)+"\n"), "expr.hcl", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("hcl: invalid expression %q: %s", expr, diags.Error())
+	}
+	return f.Body().GetAttribute("x").Expr().BuildTokens(nil), nil
+}
+
+// toCty converts a Go value to a cty value.
+func toCty(v interface{}) (cty.Value, error) {
+	switch t := v.(type) {
+	case nil:
+		return cty.NullVal(cty.DynamicPseudoType), nil
+	case string:
+		return cty.StringVal(t), nil
+	case Expression:
+		return cty.StringVal(string(t)), nil
+	case bool:
+		return cty.BoolVal(t), nil
+	case int:
+		return cty.NumberIntVal(int64(t)), nil
+	case int64:
+		return cty.NumberIntVal(t), nil
+	case uint64:
+		return cty.NumberUIntVal(t), nil
+	case float64:
+		return cty.NumberFloatVal(t), nil
+	case []interface{}:
+		vals := make([]cty.Value, len(t))
+		for i, e := range t {
+			val, err := toCty(e)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			vals[i] = val
+		}
+		return cty.TupleVal(vals), nil
+	case map[string]interface{}:
+		vals := make(map[string]cty.Value, len(t))
+		for k, e := range t {
+			val, err := toCty(e)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			vals[k] = val
+		}
+		return cty.ObjectVal(vals), nil
+	}
+	return cty.NilVal, fmt.Errorf("unsupported type %T", v)
+}
+
+// writeComments writes the comments of k in m; a blank line is not written before the first item of a body.
+func writeComments(body *hclwrite.Body, m map[string]interface{}, k string, first bool) {
+	for _, comment := range commentsFor(m, k) {
+		if comment == "" {
+			if !first {
+				body.AppendNewline()
+			}
+			continue
+		}
+		for _, line := range strings.Split(comment, "\n") {
+			body.AppendUnstructuredTokens(hclwrite.Tokens{
+				{Type: hclsyntax.TokenComment, Bytes: []byte("# " + line + "\n")},
+			})
+		}
+	}
+}
+
+func isCommentKey(k string) bool {
+	return strings.HasSuffix(k, config.CommentSuffix) && commentTarget(k) != ""
+}
+
+// commentTarget returns the key a comment key belongs to: "port______" and "port_______" both belong to "port".
+func commentTarget(k string) string {
+	return strings.TrimRight(strings.TrimSuffix(k, config.CommentSuffix), "_")
+}
+
+// commentsFor returns the comments for k in m, ordered by comment key.
+func commentsFor(m map[string]interface{}, k string) []string {
+	var keys []string
+	for ck := range m {
+		if isCommentKey(ck) && commentTarget(ck) == k {
+			keys = append(keys, ck)
+		}
+	}
+	sort.Strings(keys)
+
+	comments := make([]string, 0, len(keys))
+	for _, ck := range keys {
+		if m[ck] == nil {
+			comments = append(comments, "")
+			continue
+		}
+		comments = append(comments, strings.TrimSpace(fmt.Sprint(m[ck])))
+	}
+	return comments
+}
+
+// Version reads the version and force attributes.
+func (h *Hcl) Version(data []byte) (int, bool, error) {
+	var out map[string]interface{}
+	if err := h.Unmarshal(data, &out); err != nil {
+		return 0, false, err
+	}
+
+	version, _ := out["version"].(int)
+	force, _ := out["force"].(bool)
+	return version, force, nil
+}
+
+// EmptyData returns an empty HCL file.
+func (*Hcl) EmptyData() []byte {
+	return []byte{}
+}
+
+// Verbatim reports that Marshal output is written as is: strings are quoted and expressions are kept as written, so
+// quotes and "null" are part of the values.
+func (*Hcl) Verbatim() bool {
+	return true
+}
@@ -0,0 +1,430 @@
+package hcl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	config "github.com/c2pc/config-migrate/driver"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+const configPath = "./examples/config.hcl"
+const migrationsPath = "./examples/migrations"
+
+func getConfig() database.Driver {
+	return New(config.Settings{
+		Path:                    configPath,
+		Perm:                    0777,
+		UnableToReplaceComments: true,
+	})
+}
+
+func getSourceURL() string {
+	return fmt.Sprintf("file://%s", migrationsPath)
+}
+
+func readConfigFile(path string) (map[string]interface{}, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, config.DefaultPerm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fileData, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	fileMap := map[string]interface{}{}
+	if err := (&Hcl{}).Unmarshal(fileData, &fileMap); err != nil {
+		return nil, err
+	}
+
+	return fileMap, nil
+}
+
+func TestNew(t *testing.T) {
+	var _ database.Driver
+	_ = New(config.Settings{})
+}
+
+func TestOpen(t *testing.T) {
+	hclDriver := New(config.Settings{})
+
+	_, err := hclDriver.Open("1http://foo.com")
+	if err == nil {
+		t.Fatal("expected an error when calling Open with invalid path")
+	}
+
+	_, err = hclDriver.Open(getSourceURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLock_Unlock(t *testing.T) {
+	defer os.Remove(configPath)
+
+	hclDriver := New(config.Settings{})
+
+	_, err := hclDriver.Open("hcl://" + configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hclDriver.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := hclDriver.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readConfigFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUp1(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "hcl", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := `data_dir = "/opt/nomad/data"
+
+# Datacenter the agent runs in
+datacenter = "dc1"
+force      = false
+version    = 1
+
+client {
+  enabled = true
+  meta = {
+    rack = "r1"
+  }
+  servers = ["10.0.0.1:4647", "10.0.0.2:4647"]
+}
+
+# Task drivers
+plugin "docker" {
+  config {
+    allow_privileged = false
+  }
+}
+`
+
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 1 {
+		t.Errorf("Expected version %d, got: %d", 1, v)
+	}
+
+	if f != false {
+		t.Errorf("Expected force %t, got: %t", false, f)
+	}
+}
+
+func TestUp2(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "hcl", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := map[string]interface{}{
+		"version": 2, "force": false,
+		"datacenter": "dc1",
+		"data_dir":   "/opt/nomad/data",
+		"bind_addr":  "{{ GetPrivateIP }}",
+		"client": map[string]interface{}{
+			"enabled": true,
+			"servers": []interface{}{"10.0.0.1:4647", "10.0.0.2:4647"},
+			"meta":    map[string]interface{}{"rack": "r1"},
+			"host_volume": map[string]interface{}{
+				"certs": map[string]interface{}{"path": "/etc/ssl/certs", "read_only": true},
+			},
+		},
+		"plugin": map[string]interface{}{
+			"docker":   map[string]interface{}{"config": map[string]interface{}{"allow_privileged": true}},
+			"raw_exec": map[string]interface{}{"config": map[string]interface{}{"enabled": true}},
+		},
+		"telemetry": map[string]interface{}{
+			"publish_allocation_metrics": true,
+			"prometheus_metrics":         true,
+		},
+		shapesKey: "client.host_volume:1,client.meta:attr,client:0,plugin.config:0,plugin:1,telemetry:0",
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, result)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Labeled blocks and object attributes are written back the way the migrations wrote them
+	for _, s := range []string{`host_volume "certs" {`, `plugin "raw_exec" {`, "meta = {"} {
+		if !bytes.Contains(data, []byte(s)) {
+			t.Errorf("Expected %q in:\n%s", s, data)
+		}
+	}
+}
+
+func TestUp_KeepsExpressions(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "hcl", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// The operator binds to an interpolated address before migration 2 adds bind_addr
+	f, err := os.OpenFile(configPath, os.O_APPEND|os.O_WRONLY, 0777)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = f.WriteString("bind_addr = \"${attr.unique.network.ip-address}\"\n")
+	f.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if expected := Expression(`"${attr.unique.network.ip-address}"`); result["bind_addr"] != expected {
+		t.Errorf("Expected bind_addr %v, got: %v", expected, result["bind_addr"])
+	}
+}
+
+func TestUp_KeepsQuotesAndNull(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.hcl")
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0777); err != nil {
+		t.Fatal(err)
+	}
+	up := "name = \"O'Brien\"\nlog = \"/dev/null\"\naddr = coalesce(var.addr, \"/dev/null\")\n"
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.up.hcl"), []byte(up), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.down.hcl"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(config.Settings{Path: path, VersionFile: path + ".migrate.json"})
+	m, err := migrate.NewWithDatabaseInstance("file://"+filepath.ToSlash(migrations), "hcl", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"name": "O'Brien",
+		"log":  "/dev/null",
+		"addr": Expression(`coalesce(var.addr, "/dev/null")`),
+	}
+	delete(result, shapesKey)
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, result)
+	}
+}
+
+func TestUp3_Invalid_Migration_File(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "hcl", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(3); err == nil {
+		t.Error("expected error")
+		return
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 3 {
+		t.Errorf("Expected version %d, got: %d", 3, v)
+	}
+
+	if f != true {
+		t.Errorf("Expected force %t, got: %t", true, f)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "hcl", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Drop(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(result) != 0 {
+		t.Errorf("Expected empty config after Drop, got: %v", result)
+	}
+}
+
+func TestMarshal_RoundTrip(t *testing.T) {
+	data := []byte(`ratio = 1.5
+addr  = "http://${attr.unique.network.ip-address}:4646"
+port  = upper("x")
+
+template {
+  source      = "a.tpl"
+  destination = "a"
+}
+
+template {
+  source      = "b.tpl"
+  destination = "b"
+}
+
+job "web" {
+  group "app" {
+    count = 2
+  }
+
+  group "worker" {
+    count = 1
+  }
+}
+`)
+
+	h := &Hcl{}
+	in := map[string]interface{}{}
+	if err := h.Unmarshal(data, &in); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"ratio": 1.5,
+		"addr":  Expression(`"http://${attr.unique.network.ip-address}:4646"`),
+		"port":  Expression(`upper("x")`),
+		"template": []interface{}{
+			map[string]interface{}{"source": "a.tpl", "destination": "a"},
+			map[string]interface{}{"source": "b.tpl", "destination": "b"},
+		},
+		"job": map[string]interface{}{
+			"web": map[string]interface{}{
+				"group": map[string]interface{}{
+					"app":    map[string]interface{}{"count": 2},
+					"worker": map[string]interface{}{"count": 1},
+				},
+			},
+		},
+		shapesKey: "job.group:1,job:1,template:0",
+	}
+	if !reflect.DeepEqual(in, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, in)
+	}
+
+	// The shapes are part of the data, not of the driver
+	b, err := (&Hcl{}).Marshal(in, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := map[string]interface{}{}
+	if err := h.Unmarshal(b, &out); err != nil {
+		t.Fatalf("unmarshal: %v\n%s", err, b)
+	}
+
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Expected: %v, got: %v\n%s", in, out, b)
+	}
+}
@@ -0,0 +1,136 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/c2pc/config-migrate/internal/url"
+	"github.com/pkg/errors"
+)
+
+// Migration directions recorded in the history.
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+)
+
+// HistoryEntry is one applied migration in the history file.
+type HistoryEntry struct {
+	// Version — the version the migration led to; database.NilVersion after the first migration was rolled back.
+	Version int `json:"version"`
+
+	// Direction — DirectionUp or DirectionDown.
+	Direction string `json:"direction"`
+
+	// Time — when the migration was finished, in UTC.
+	Time time.Time `json:"time"`
+
+	// Hostname — the host that applied the migration.
+	Hostname string `json:"hostname"`
+
+	// MigrationChecksum — hex SHA-256 of the migration body; empty if the migration had no body.
+	MigrationChecksum string `json:"migration_checksum"`
+
+	// FileChecksum — hex SHA-256 of the config file after the migration.
+	FileChecksum string `json:"file_checksum"`
+}
+
+// History returns the entries of the history file, oldest first. It returns nil if there is no history.
+func (m *Config) History() ([]HistoryEntry, error) {
+	if m.historyFile == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(m.historyFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		entry := HistoryEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s:%d", m.historyFile, line)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// setHistoryFile configures the history file.
+func (m *Config) setHistoryFile(historyFile string) error {
+	m.historyFile = ""
+	if historyFile == "" {
+		return nil
+	}
+
+	path, err := url.ParseURL(historyFile)
+	if err != nil {
+		return err
+	}
+	m.historyFile = path
+	return nil
+}
+
+// appendHistory appends the finished migration step, which led to version, to the history file.
+func (m *Config) appendHistory(step *migrationStep, version int) error {
+	if m.historyFile == "" || m.dryRun != nil {
+		return nil
+	}
+
+	fileData, err := m.readFile()
+	if err != nil {
+		return err
+	}
+
+	direction := DirectionUp
+	if version < step.from {
+		direction = DirectionDown
+	}
+
+	hostname, _ := os.Hostname()
+
+	line, err := json.Marshal(HistoryEntry{
+		Version:           version,
+		Direction:         direction,
+		Time:              time.Now().UTC(),
+		Hostname:          hostname,
+		MigrationChecksum: step.migrationChecksum,
+		FileChecksum:      checksum(fileData),
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(m.historyFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, m.perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// checksum returns the hex SHA-256 of data.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
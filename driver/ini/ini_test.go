@@ -1,10 +1,12 @@
 package ini
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 
 	config "github.com/c2pc/config-migrate/driver"
@@ -396,3 +398,125 @@ func TestDrop(t *testing.T) {
 		t.Errorf("Expected after Drop: %v, got: %v", expectedMap, result)
 	}
 }
+
+func TestUp1_VersionKey(t *testing.T) {
+	defer os.Remove(configPath)
+
+	d := New(config.Settings{
+		Path:       configPath,
+		Perm:       0777,
+		VersionKey: "_meta.schema_version",
+	})
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "ini", d)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// version and force from the migration file are ordinary keys now
+	expected := map[string]interface{}{
+		"": map[string]interface{}{
+			"version": "1", "force": "false", "str": "str", "number": "1", "boolean": "true",
+		},
+		"version": "1", "force": "false", "str": "str", "number": "1", "boolean": "true",
+		"host": map[string]interface{}{
+			"url": "url", "host": "host10",
+		},
+		"_meta": map[string]interface{}{
+			"schema_version": "1", "force": "false",
+		},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, result)
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 1 {
+		t.Errorf("Expected version %d, got: %d", 1, v)
+	}
+
+	if f != false {
+		t.Errorf("Expected force %t, got: %t", false, f)
+	}
+}
+
+func TestUp2_SchemaRollback(t *testing.T) {
+	defer os.Remove(configPath)
+
+	d := New(config.Settings{
+		Path:   configPath,
+		Perm:   0777,
+		Schema: []byte(`{"type": "object", "required": ["str", "host"]}`),
+	})
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "ini", d)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	before, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Migration 2 drops host
+	err = m.Steps(1)
+	var schemaErr *config.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Errorf("Expected SchemaError, got: %v", err)
+		return
+	}
+
+	if schemaErr.Version != 2 || len(schemaErr.Errors) != 1 || !strings.HasPrefix(schemaErr.Errors[0], "(root): missing properties: 'host'") {
+		t.Errorf("Unexpected schema error: %v", schemaErr)
+	}
+
+	after, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if string(after) != string(before) {
+		t.Errorf("Expected config to be restored:\n%s\ngot:\n%s", before, after)
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 1 {
+		t.Errorf("Expected version %d, got: %d", 1, v)
+	}
+
+	if f != false {
+		t.Errorf("Expected force %t, got: %t", false, f)
+	}
+}
@@ -0,0 +1,264 @@
+package jsonc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/c2pc/config-migrate/driver"
+	"github.com/golang-migrate/migrate/v4/database"
+)
+
+// Jsonc implements driver.Driver for JSON with comments, as used by VS Code settings and tsconfig.json.
+// Files may contain // and /* */ comments and trailing commas in objects and arrays. Values are decoded the same
+// way as by the JSON driver, so numbers are float64.
+//
+// Comments are read but not kept: the config is written from the merged data, so the only comments of a migrated
+// file are those of comment keys (see config.CommentSuffix), and hand-written comments are dropped.
+type Jsonc struct{}
+
+func init() {
+	config.Register("jsonc", &Jsonc{}, config.Settings{})
+}
+
+// New returns a database.Driver that uses the JSONC driver with the given settings.
+func New(cfg config.Settings) database.Driver {
+	return config.New(&Jsonc{}, cfg)
+}
+
+// Unmarshal strips comments and trailing commas and decodes the remaining JSON with encoding/json.
+func (Jsonc) Unmarshal(data []byte, out interface{}) error {
+	std, err := standardize(data)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(std)) == 0 {
+		std = []byte(`{}`)
+	}
+	return json.Unmarshal(std, out)
+}
+
+// standardize converts JSONC to JSON: comments and trailing commas are replaced with spaces,
+// so offsets reported by encoding/json still point at the same lines.
+func standardize(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	comma := -1   // Offset in out of the last comma not yet followed by a value
+	var last byte // Last byte of JSON written to out, ignoring whitespace
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '"':
+			start := i
+			for i++; i < len(data) && data[i] != '"'; i++ {
+				if data[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(data) {
+				return nil, fmt.Errorf("jsonc: unterminated string at offset %d", start)
+			}
+			out = append(out, data[start:i+1]...)
+			comma, last = -1, '"'
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for ; i < len(data) && data[i] != '\n'; i++ {
+				out = append(out, ' ')
+			}
+			if i < len(data) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := bytes.Index(data[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, fmt.Errorf("jsonc: unterminated comment at offset %d", i)
+			}
+			for _, b := range data[i : i+2+end+2] {
+				if b == '\n' {
+					out = append(out, '\n')
+				} else {
+					out = append(out, ' ')
+				}
+			}
+			i += 2 + end + 1
+		case c == ',':
+			// A comma right after '[', '{' or another comma is left for encoding/json to reject
+			comma = -1
+			if last != '[' && last != '{' && last != ',' {
+				comma = len(out)
+			}
+			out = append(out, c)
+			last = c
+		case c == '}' || c == ']':
+			if comma >= 0 {
+				out[comma] = ' '
+			}
+			comma = -1
+			out = append(out, c)
+			last = c
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			out = append(out, c)
+		default:
+			comma = -1
+			out = append(out, c)
+			last = c
+		}
+	}
+	return out, nil
+}
+
+// Marshal serializes the map to JSON indented with four spaces, keys sorted. If replaceComments is true,
+// keys ending with config.CommentSuffix are written as // comments above the key they belong to;
+// an empty comment becomes a blank line.
+func (Jsonc) Marshal(i interface{}, replaceComments bool) ([]byte, error) {
+	w := &writer{replaceComments: replaceComments}
+	if err := w.value(i, 0); err != nil {
+		return nil, err
+	}
+	w.buf.WriteString("\n")
+	return w.buf.Bytes(), nil
+}
+
+type writer struct {
+	buf             bytes.Buffer
+	replaceComments bool
+}
+
+func (w *writer) value(v interface{}, depth int) error {
+	indent := strings.Repeat("    ", depth+1)
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			if w.replaceComments && isCommentKey(k) {
+				continue
+			}
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if len(keys) == 0 {
+			w.buf.WriteString("{}")
+			return nil
+		}
+
+		w.buf.WriteString("{\n")
+		for n, k := range keys {
+			if w.replaceComments {
+				for _, comment := range commentsFor(t, k) {
+					if comment == "" {
+						if n > 0 {
+							w.buf.WriteString("\n")
+						}
+						continue
+					}
+					for _, line := range strings.Split(comment, "\n") {
+						w.buf.WriteString(indent + "// " + line + "\n")
+					}
+				}
+			}
+			w.buf.WriteString(indent)
+			if err := w.scalar(k); err != nil {
+				return err
+			}
+			w.buf.WriteString(": ")
+			if err := w.value(t[k], depth+1); err != nil {
+				return err
+			}
+			if n < len(keys)-1 {
+				w.buf.WriteString(",")
+			}
+			w.buf.WriteString("\n")
+		}
+		w.buf.WriteString(strings.Repeat("    ", depth) + "}")
+	case []interface{}:
+		if len(t) == 0 {
+			w.buf.WriteString("[]")
+			return nil
+		}
+
+		w.buf.WriteString("[\n")
+		for n, e := range t {
+			w.buf.WriteString(indent)
+			if err := w.value(e, depth+1); err != nil {
+				return err
+			}
+			if n < len(t)-1 {
+				w.buf.WriteString(",")
+			}
+			w.buf.WriteString("\n")
+		}
+		w.buf.WriteString(strings.Repeat("    ", depth) + "]")
+	default:
+		return w.scalar(t)
+	}
+	return nil
+}
+
+// scalar writes v with encoding/json without escaping HTML characters.
+func (w *writer) scalar(v interface{}) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	w.buf.Write(bytes.TrimRight(b.Bytes(), "\n"))
+	return nil
+}
+
+func isCommentKey(k string) bool {
+	return strings.HasSuffix(k, config.CommentSuffix) && commentTarget(k) != ""
+}
+
+// commentTarget returns the key a comment key belongs to: "host______" and "host_______" both belong to "host".
+func commentTarget(k string) string {
+	return strings.TrimRight(strings.TrimSuffix(k, config.CommentSuffix), "_")
+}
+
+// commentsFor returns the comments for k in m, ordered by comment key.
+func commentsFor(m map[string]interface{}, k string) []string {
+	var keys []string
+	for ck := range m {
+		if isCommentKey(ck) && commentTarget(ck) == k {
+			keys = append(keys, ck)
+		}
+	}
+	sort.Strings(keys)
+
+	comments := make([]string, 0, len(keys))
+	for _, ck := range keys {
+		if m[ck] == nil {
+			comments = append(comments, "")
+			continue
+		}
+		comments = append(comments, strings.TrimSpace(fmt.Sprint(m[ck])))
+	}
+	return comments
+}
+
+type version struct {
+	Version int  `json:"version"`
+	Force   bool `json:"force"`
+}
+
+// Version reads version and force.
+func (j Jsonc) Version(data []byte) (int, bool, error) {
+	v := new(version)
+	if err := j.Unmarshal(data, v); err != nil {
+		return 0, false, err
+	}
+	return v.Version, v.Force, nil
+}
+
+// EmptyData returns an empty JSON object.
+func (Jsonc) EmptyData() []byte {
+	return []byte("{}")
+}
+
+// Verbatim reports that Marshal output is written as is: strings are quoted, so quotes are part of the values and
+// "null" is a value.
+func (Jsonc) Verbatim() bool {
+	return true
+}
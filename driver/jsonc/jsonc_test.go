@@ -0,0 +1,360 @@
+package jsonc
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	config "github.com/c2pc/config-migrate/driver"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+const configPath = "./examples/config.jsonc"
+const migrationsPath = "./examples/migrations"
+
+func getConfig() database.Driver {
+	return New(config.Settings{
+		Path:                    configPath,
+		Perm:                    0777,
+		UnableToReplaceComments: true,
+	})
+}
+
+func getSourceURL() string {
+	return fmt.Sprintf("file://%s", migrationsPath)
+}
+
+func readConfigFile(path string) (map[string]interface{}, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, config.DefaultPerm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fileData, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	fileMap := map[string]interface{}{}
+	if err := (Jsonc{}).Unmarshal(fileData, &fileMap); err != nil {
+		return nil, err
+	}
+
+	return fileMap, nil
+}
+
+func TestNew(t *testing.T) {
+	var _ database.Driver
+	_ = New(config.Settings{})
+}
+
+func TestOpen(t *testing.T) {
+	jsoncDriver := New(config.Settings{})
+
+	_, err := jsoncDriver.Open("1http://foo.com")
+	if err == nil {
+		t.Fatal("expected an error when calling Open with invalid path")
+	}
+
+	_, err = jsoncDriver.Open(getSourceURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLock_Unlock(t *testing.T) {
+	defer os.Remove(configPath)
+
+	jsoncDriver := New(config.Settings{})
+
+	_, err := jsoncDriver.Open("jsonc://" + configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jsoncDriver.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := jsoncDriver.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readConfigFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUp1(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "jsonc", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := `{
+    // Font size in pixels
+    "editor.fontSize": 14,
+    "editor.tabSize": 4,
+    "files.exclude": {
+        "**/.git": true,
+        "**/node_modules": true
+    },
+    "force": false,
+    "version": 1
+}
+`
+
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 1 {
+		t.Errorf("Expected version %d, got: %d", 1, v)
+	}
+
+	if f != false {
+		t.Errorf("Expected force %t, got: %t", false, f)
+	}
+}
+
+func TestUp2(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "jsonc", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Hand-written comments and trailing commas do not break the next migration
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data = append([]byte("// Edited by hand\n"), data...)
+	data = []byte(string(data[:len(data)-len("\n}\n")]) + ",\n    /* trailing */\n}\n")
+	if err := os.WriteFile(configPath, data, 0777); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := map[string]interface{}{
+		"version": float64(2), "force": false,
+		"editor.fontSize": float64(14),
+		"editor.tabSize":  float64(2),
+		"files.exclude": map[string]interface{}{
+			"**/.git": true, "**/node_modules": true, "**/dist": true,
+		},
+		"compilerOptions": map[string]interface{}{
+			"strict": true,
+			"paths":  map[string]interface{}{"@app/*": []interface{}{"src/*"}},
+		},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, result)
+	}
+}
+
+func TestUp3_Invalid_Migration_File(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "jsonc", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(3); err == nil {
+		t.Error("expected error")
+		return
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 3 {
+		t.Errorf("Expected version %d, got: %d", 3, v)
+	}
+
+	if f != true {
+		t.Errorf("Expected force %t, got: %t", true, f)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "jsonc", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Drop(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(result) != 0 {
+		t.Errorf("Expected empty config after Drop, got: %v", result)
+	}
+}
+
+func TestUp_KeepsQuotesAndNull(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.jsonc")
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0777); err != nil {
+		t.Fatal(err)
+	}
+	up := `{
+    "name": "O'Brien",
+    "log": "/dev/null",
+    "proxy": null,
+}
+`
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.up.jsonc"), []byte(up), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.down.jsonc"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(config.Settings{Path: path, VersionFile: path + ".migrate.json"})
+	m, err := migrate.NewWithDatabaseInstance("file://"+filepath.ToSlash(migrations), "jsonc", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{
+    "log": "/dev/null",
+    "name": "O'Brien",
+    "proxy": null
+}
+`
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	data := `/* header */
+{
+    // line comment
+    "url": "http://example.com/*not a comment*/", // after value
+    "escaped": "quote \" // still a string",
+    "list": [1, 2, 3,],
+    "nested": {"a": true,},
+}`
+
+	out := map[string]interface{}{}
+	if err := (Jsonc{}).Unmarshal([]byte(data), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"url":     "http://example.com/*not a comment*/",
+		"escaped": "quote \" // still a string",
+		"list":    []interface{}{float64(1), float64(2), float64(3)},
+		"nested":  map[string]interface{}{"a": true},
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, out)
+	}
+
+	for _, invalid := range []string{`{"a": 1 /* open`, `{"a": "open}`, `{"a": [,]}`} {
+		if err := (Jsonc{}).Unmarshal([]byte(invalid), &out); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestMarshal_RoundTrip(t *testing.T) {
+	in := map[string]interface{}{
+		"html":   "<b>&</b>",
+		"empty":  map[string]interface{}{},
+		"list":   []interface{}{},
+		"nested": []interface{}{map[string]interface{}{"a": float64(1)}, "s", nil},
+	}
+
+	b, err := (Jsonc{}).Marshal(in, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := map[string]interface{}{}
+	if err := (Jsonc{}).Unmarshal(b, &out); err != nil {
+		t.Fatalf("unmarshal: %v\n%s", err, b)
+	}
+
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Expected: %v, got: %v\n%s", in, out, b)
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// PlanStep is the result of one migration applied in dry-run mode.
+type PlanStep struct {
+	// Version — the version the migration leads to.
+	Version int
+
+	// Data — the config content right after the migration was merged.
+	Data []byte
+}
+
+// dryRunState holds the would-be config and version state while Settings.DryRun is enabled.
+type dryRunState struct {
+	data        []byte           // Would-be config content
+	loaded      bool             // Whether data replaced the content of the file
+	version     *versionFileData // Would-be sidecar version state
+	dropVersion bool             // Whether the sidecar version file would be removed
+	steps       []PlanStep       // Results of the migrations applied so far
+}
+
+// Plan returns the results of the migrations applied in dry-run mode and the would-be final config content.
+// Without dry-run it returns nil steps and the current content of the config file.
+func (m *Config) Plan() ([]PlanStep, []byte, error) {
+	if m.dryRun != nil && m.dryRun.loaded {
+		return append([]PlanStep(nil), m.dryRun.steps...), m.dryRun.data, nil
+	}
+
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	return nil, data, err
+}
+
+// readFile returns the current config content: the would-be content in dry-run mode, otherwise the locked file.
+func (m *Config) readFile() ([]byte, error) {
+	if m.dryRun != nil && m.dryRun.loaded {
+		return m.dryRun.data, nil
+	}
+	if m.lockedFile == nil {
+		if m.dryRun != nil {
+			// Dry run on a config file that does not exist yet
+			return nil, nil
+		}
+		// Not locked yet; Version locks the file on fs.ErrClosed
+		return nil, errors.Wrapf(fs.ErrClosed, "%s is not locked", m.path)
+	}
+
+	if _, err := m.lockedFile.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(m.lockedFile)
+}
+
+// recordStep stores the result of a migration applied in dry-run mode.
+func (m *Config) recordStep(data []byte) {
+	m.dryRun.steps = append(m.dryRun.steps, PlanStep{Version: m.target, Data: data})
+}
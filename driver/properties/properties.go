@@ -0,0 +1,366 @@
+package properties
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/c2pc/config-migrate/driver"
+	"github.com/golang-migrate/migrate/v4/database"
+)
+
+// ValueKey is the key of the value of a key that is also the prefix of other keys:
+// "log4j.appender.A1=x" and "log4j.appender.A1.layout=y" → "A1": {"#value": "x", "layout": "y"}.
+const ValueKey = "#value"
+
+// Properties implements driver.Driver for Java .properties files.
+// Dotted keys are read into nested maps, so "spring.datasource.url" is the path spring → datasource → url.
+// All values are strings.
+type Properties struct{}
+
+func init() {
+	config.Register("properties", &Properties{}, config.Settings{})
+}
+
+// New returns a database.Driver that uses the properties driver with the given settings.
+func New(cfg config.Settings) database.Driver {
+	return config.New(&Properties{}, cfg)
+}
+
+// Unmarshal parses a .properties file into nested maps. It follows java.util.Properties.load: '#' and '!' comments,
+// '=', ':' or whitespace between key and value, lines continued with a trailing backslash and \t, \n, \r, \f and
+// \uXXXX escapes.
+func (p Properties) Unmarshal(data []byte, out interface{}) error {
+	ptr, ok := out.(*map[string]interface{})
+	if !ok {
+		return fmt.Errorf("properties: out must be *map[string]interface{}")
+	}
+
+	m := map[string]interface{}{}
+	lines := strings.Split(strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(string(data)), "\n")
+	for i := 0; i < len(lines); i++ {
+		start := i
+		line := strings.TrimLeft(lines[i], " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+
+		// Join continuation lines; leading whitespace of each continuation is dropped
+		for continues(line) && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+		if continues(line) {
+			line = line[:len(line)-1]
+		}
+
+		rawKey, rawValue := splitKeyValue(line)
+		key, err := unescape(rawKey)
+		if err != nil {
+			return fmt.Errorf("properties: line %d: %w", start+1, err)
+		}
+		value, err := unescape(rawValue)
+		if err != nil {
+			return fmt.Errorf("properties: line %d: %w", start+1, err)
+		}
+
+		if err := set(m, key, value); err != nil {
+			return fmt.Errorf("properties: line %d: %w", start+1, err)
+		}
+	}
+
+	*ptr = m
+	return nil
+}
+
+// continues reports whether the line ends with an odd number of backslashes.
+func continues(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// splitKeyValue splits a logical line at the first unescaped '=', ':' or whitespace.
+func splitKeyValue(line string) (string, string) {
+	i := 0
+	for ; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' {
+			i++
+			continue
+		}
+		if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' {
+			break
+		}
+	}
+	if i > len(line) {
+		i = len(line)
+	}
+	key, rest := line[:i], line[i:]
+
+	rest = strings.TrimLeft(rest, " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	return key, rest
+}
+
+// unescape resolves backslash escapes; an unknown escape stands for the character itself.
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var b strings.Builder
+	var units []uint16 // Pending \u escapes, decoded together to join surrogate pairs
+	flush := func() {
+		if len(units) > 0 {
+			b.WriteString(string(utf16.Decode(units)))
+			units = units[:0]
+		}
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			flush()
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		if s[i] == 'u' {
+			if i+5 > len(s) {
+				return "", fmt.Errorf("malformed \\uxxxx escape")
+			}
+			u, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\uxxxx escape")
+			}
+			units = append(units, uint16(u))
+			i += 4
+			continue
+		}
+
+		flush()
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	flush()
+	return b.String(), nil
+}
+
+// set stores value at the dotted key path in m. The value of a key that is also a prefix is stored under ValueKey.
+func set(m map[string]interface{}, key, value string) error {
+	parts := strings.Split(key, ".")
+	for _, part := range parts[:len(parts)-1] {
+		switch child := m[part].(type) {
+		case map[string]interface{}:
+			m = child
+		case nil:
+			next := map[string]interface{}{}
+			m[part] = next
+			m = next
+		default:
+			next := map[string]interface{}{ValueKey: child}
+			m[part] = next
+			m = next
+		}
+	}
+
+	last := parts[len(parts)-1]
+	if child, ok := m[last].(map[string]interface{}); ok {
+		child[ValueKey] = value
+		return nil
+	}
+	m[last] = value
+	return nil
+}
+
+// Marshal serializes nested maps to dotted keys sorted by name. Arrays are written as comma-separated values.
+// If replaceComments is true, keys ending with config.CommentSuffix are written as '#' comments above the key
+// they belong to; an empty comment becomes a blank line.
+func (p Properties) Marshal(i interface{}, replaceComments bool) ([]byte, error) {
+	m, ok := i.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("properties: expected map[string]interface{}")
+	}
+
+	props := map[string]interface{}{}
+	flatten(props, "", m)
+
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		if replaceComments && isCommentKey(k) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	commented := map[string]bool{}
+	for _, k := range keys {
+		if replaceComments {
+			// Comments of a group ("db______") go above its first key, followed by the comments of the key itself
+			parts := strings.Split(k, ".")
+			for n := 1; n <= len(parts); n++ {
+				target := strings.Join(parts[:n], ".")
+				if commented[target] {
+					continue
+				}
+				commented[target] = true
+
+				for _, comment := range commentsFor(props, target) {
+					if comment == "" {
+						if buf.Len() > 0 {
+							buf.WriteString("\n")
+						}
+						continue
+					}
+					for _, line := range strings.Split(comment, "\n") {
+						buf.WriteString("# " + line + "\n")
+					}
+				}
+			}
+		}
+		buf.WriteString(escape(k, true) + "=" + escape(formatValue(props[k]), false) + "\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// flatten stores the values of m in props under dotted keys.
+func flatten(props map[string]interface{}, prefix string, m map[string]interface{}) {
+	for k, v := range m {
+		key := prefix + k
+		if k == ValueKey && prefix != "" {
+			key = strings.TrimSuffix(prefix, ".")
+		}
+		if child, ok := v.(map[string]interface{}); ok {
+			flatten(props, key+".", child)
+			continue
+		}
+		props[key] = v
+	}
+}
+
+func formatValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case []interface{}:
+		parts := make([]string, len(t))
+		for i, e := range t {
+			parts[i] = fmt.Sprint(e)
+		}
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// escape escapes s as java.util.Properties.store does: separators and comment characters in keys, a leading space
+// in values, control characters and non-ASCII characters as \uXXXX.
+func escape(s string, isKey bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == ' ' && (isKey || i == 0):
+			b.WriteString(`\ `)
+		case isKey && (r == '=' || r == ':' || r == '#' || r == '!'):
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			for _, u := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(&b, `\u%04X`, u)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func isCommentKey(k string) bool {
+	return strings.HasSuffix(k, config.CommentSuffix) && commentTarget(k) != ""
+}
+
+// commentTarget returns the key a comment key belongs to: "db.url______" and "db.url_______" both belong to "db.url".
+func commentTarget(k string) string {
+	return strings.TrimRight(strings.TrimSuffix(k, config.CommentSuffix), "_")
+}
+
+// commentsFor returns the comments for key in props, ordered by comment key.
+func commentsFor(props map[string]interface{}, key string) []string {
+	var keys []string
+	for k := range props {
+		if isCommentKey(k) && commentTarget(k) == key {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	comments := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if props[k] == nil {
+			comments = append(comments, "")
+			continue
+		}
+		comments = append(comments, strings.TrimSpace(fmt.Sprint(props[k])))
+	}
+	return comments
+}
+
+// Version reads version and force (all values are stored as strings).
+func (p Properties) Version(data []byte) (int, bool, error) {
+	var out map[string]interface{}
+	if err := p.Unmarshal(data, &out); err != nil {
+		return 0, false, err
+	}
+
+	version := 0
+	if s, ok := out["version"].(string); ok {
+		version, _ = strconv.Atoi(s)
+	}
+	force := false
+	if s, ok := out["force"].(string); ok {
+		force, _ = strconv.ParseBool(s)
+	}
+	return version, force, nil
+}
+
+// EmptyData returns an empty properties file.
+func (Properties) EmptyData() []byte {
+	return []byte{}
+}
+
+// Verbatim reports that Marshal output is written as is: values are escaped, so quotes and "null" are part of them.
+func (Properties) Verbatim() bool {
+	return true
+}
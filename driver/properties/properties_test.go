@@ -0,0 +1,457 @@
+package properties
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	config "github.com/c2pc/config-migrate/driver"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+const configPath = "./examples/config.properties"
+const migrationsPath = "./examples/migrations"
+
+func getConfig() database.Driver {
+	return New(config.Settings{
+		Path:                    configPath,
+		Perm:                    0777,
+		UnableToReplaceComments: true,
+	})
+}
+
+func getSourceURL() string {
+	return fmt.Sprintf("file://%s", migrationsPath)
+}
+
+func readConfigFile(path string) (map[string]interface{}, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, config.DefaultPerm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fileData, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	fileMap := map[string]interface{}{}
+	if err := (Properties{}).Unmarshal(fileData, &fileMap); err != nil {
+		return nil, err
+	}
+
+	return fileMap, nil
+}
+
+func TestNew(t *testing.T) {
+	var _ database.Driver
+	_ = New(config.Settings{})
+}
+
+func TestOpen(t *testing.T) {
+	propertiesDriver := New(config.Settings{})
+
+	_, err := propertiesDriver.Open("1http://foo.com")
+	if err == nil {
+		t.Fatal("expected an error when calling Open with invalid path")
+	}
+
+	_, err = propertiesDriver.Open(getSourceURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLock_Unlock(t *testing.T) {
+	defer os.Remove(configPath)
+
+	propertiesDriver := New(config.Settings{})
+
+	_, err := propertiesDriver.Open("properties://" + configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := propertiesDriver.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := propertiesDriver.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readConfigFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUp1(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "properties", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := "force=false\n" +
+		"\n" +
+		"# Embedded server\n" +
+		"server.address=0.0.0.0\n" +
+		"server.port=8080\n" +
+		"\n" +
+		"# JDBC connection string\n" +
+		"spring.datasource.url=jdbc:postgresql://localhost:5432/app\n" +
+		"spring.datasource.username=app\n" +
+		"version=1\n"
+
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 1 {
+		t.Errorf("Expected version %d, got: %d", 1, v)
+	}
+
+	if f != false {
+		t.Errorf("Expected force %t, got: %t", false, f)
+	}
+}
+
+func TestUp2_Deprecated(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "properties", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A hand-edited value at the old path is moved by migration 2
+	f, err := os.OpenFile(configPath, os.O_APPEND|os.O_WRONLY, config.DefaultPerm)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := f.WriteString("app.greeting=Hi \\u00e9t\\u00e9\n"); err != nil {
+		t.Error(err)
+		return
+	}
+	f.Close()
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := map[string]interface{}{
+		"version": "2", "force": "false",
+		"server": map[string]interface{}{"port": "8080", "address": "0.0.0.0"},
+		"spring": map[string]interface{}{
+			"datasource": map[string]interface{}{
+				"url":      "jdbc:postgresql://localhost:5432/app",
+				"username": "app",
+			},
+		},
+		"app": map[string]interface{}{
+			"messages": map[string]interface{}{
+				"greeting": "Hi été",
+				"farewell": "Auf Wiedersehen — bis bald",
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, result)
+	}
+}
+
+func TestUp3(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "properties", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(3); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := map[string]interface{}{
+		"version": "3", "force": "false",
+		"server": map[string]interface{}{"port": "8443", "address": "0.0.0.0"},
+		"spring": map[string]interface{}{
+			"datasource": map[string]interface{}{
+				"url":      "jdbc:postgresql://localhost:5432/app",
+				"username": "app",
+			},
+		},
+		"app": map[string]interface{}{
+			"messages": map[string]interface{}{
+				"greeting": "Hello, world",
+				"farewell": "Auf Wiedersehen — bis bald",
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, result)
+	}
+}
+
+func TestUp4_Invalid_Migration_File(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "properties", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(4); err == nil {
+		t.Error("expected error")
+		return
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 4 {
+		t.Errorf("Expected version %d, got: %d", 4, v)
+	}
+
+	if f != true {
+		t.Errorf("Expected force %t, got: %t", true, f)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "properties", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Drop(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(result) != 0 {
+		t.Errorf("Expected empty config after Drop, got: %v", result)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	data := "# comment\n" +
+		"! another comment\n" +
+		"a.b = 1\n" +
+		"a.c:2\n" +
+		"spaced value\n" +
+		"long = one, \\\n" +
+		"       two\n" +
+		"key\\ with\\=sep = v\n" +
+		"unicode=\\u0048\\u00e9\\uD83D\\uDE00\n" +
+		"escapes=tab\\there\\nnewline\n" +
+		"empty\n"
+
+	out := map[string]interface{}{}
+	if err := (Properties{}).Unmarshal([]byte(data), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"a":            map[string]interface{}{"b": "1", "c": "2"},
+		"spaced":       "value",
+		"long":         "one, two",
+		"key with=sep": "v",
+		"unicode":      "Hé😀",
+		"escapes":      "tab\there\nnewline",
+		"empty":        "",
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, out)
+	}
+
+}
+
+func TestValueAndPrefix(t *testing.T) {
+	data := "log4j.appender.A1=org.apache.log4j.ConsoleAppender\n" +
+		"log4j.appender.A1.layout=org.apache.log4j.PatternLayout\n" +
+		"log4j.appender.A1.layout.ConversionPattern=%-4r %-5p %c - %m%n\n" +
+		"log4j.appender.A2.layout=org.apache.log4j.SimpleLayout\n" +
+		"log4j.appender.A2=org.apache.log4j.FileAppender\n"
+
+	out := map[string]interface{}{}
+	if err := (Properties{}).Unmarshal([]byte(data), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"log4j": map[string]interface{}{
+			"appender": map[string]interface{}{
+				"A1": map[string]interface{}{
+					ValueKey: "org.apache.log4j.ConsoleAppender",
+					"layout": map[string]interface{}{
+						ValueKey:            "org.apache.log4j.PatternLayout",
+						"ConversionPattern": "%-4r %-5p %c - %m%n",
+					},
+				},
+				"A2": map[string]interface{}{
+					ValueKey: "org.apache.log4j.FileAppender",
+					"layout": "org.apache.log4j.SimpleLayout",
+				},
+			},
+		},
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, out)
+	}
+
+	b, err := (Properties{}).Marshal(out, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := "log4j.appender.A1=org.apache.log4j.ConsoleAppender\n" +
+		"log4j.appender.A1.layout=org.apache.log4j.PatternLayout\n" +
+		"log4j.appender.A1.layout.ConversionPattern=%-4r %-5p %c - %m%n\n" +
+		"log4j.appender.A2=org.apache.log4j.FileAppender\n" +
+		"log4j.appender.A2.layout=org.apache.log4j.SimpleLayout\n"
+	if string(b) != written {
+		t.Errorf("Expected:\n%s\ngot:\n%s", written, b)
+	}
+}
+
+func TestUp_KeepsQuotesAndNull(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.properties")
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.up.properties"), []byte("name=O'Brien\nlog=/dev/null\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.down.properties"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("name=O'Neil\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := migrate.NewWithDatabaseInstance("file://"+filepath.ToSlash(migrations), "properties", New(config.Settings{Path: path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "force=false\n" +
+		"log=/dev/null\n" +
+		"name=O'Neil\n" +
+		"version=1\n"
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
+
+func TestMarshal_RoundTrip(t *testing.T) {
+	in := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": "1",
+			"c": map[string]interface{}{"d": " leading space"},
+		},
+		"key with=sep": "multi\nline",
+		"unicode":      "Hé😀",
+		"path":         `C:\temp`,
+	}
+
+	b, err := (Properties{}).Marshal(in, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "a.b=1\n" +
+		"a.c.d=\\ leading space\n" +
+		"key\\ with\\=sep=multi\\nline\n" +
+		"path=C:\\\\temp\n" +
+		"unicode=H\\u00E9\\uD83D\\uDE00\n"
+	if string(b) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, b)
+	}
+
+	out := map[string]interface{}{}
+	if err := (Properties{}).Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Expected: %v, got: %v", in, out)
+	}
+}
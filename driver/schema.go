@@ -0,0 +1,145 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/c2pc/config-migrate/internal/url"
+	"github.com/pkg/errors"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// SchemaError is returned by Run when the merged config does not match Settings.Schema.
+// The config and its version are restored to the state before the migration.
+type SchemaError struct {
+	// Version — the version the migration would have led to.
+	Version int
+
+	// Errors — the validation errors, each starting with the dot-separated key path, sorted.
+	Errors []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("config does not match the schema after migration %d:\n  %s", e.Version, strings.Join(e.Errors, "\n  "))
+}
+
+// compileSchema compiles the JSON Schema given as schema or, if empty, read from schemaFile. It returns nil if both are empty.
+func compileSchema(schema []byte, schemaFile string) (*jsonschema.Schema, error) {
+	name := "schema.json"
+	if len(schema) == 0 {
+		if schemaFile == "" {
+			return nil, nil
+		}
+
+		path, err := url.ParseURL(schemaFile)
+		if err != nil {
+			return nil, err
+		}
+		if schema, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+		name = path
+	}
+
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(name, bytes.NewReader(schema)); err != nil {
+		return nil, errors.Wrap(err, "failed to parse schema")
+	}
+	s, err := compiler.Compile(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compile schema")
+	}
+	return s, nil
+}
+
+// validate checks the merged config data against the schema. Comment keys are not validated.
+func (m *Config) validate(data map[string]interface{}) error {
+	if m.schema == nil {
+		return nil
+	}
+
+	// Bring values decoded by any driver to the types of decoded JSON
+	raw, err := json.Marshal(withoutComments(data))
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return err
+	}
+
+	err = m.schema.Validate(doc)
+	var ve *jsonschema.ValidationError
+	if !errors.As(err, &ve) {
+		return err
+	}
+
+	seen := map[string]bool{}
+	schemaErr := &SchemaError{Version: m.target}
+	for _, leaf := range validationLeaves(ve) {
+		msg := keyPath(leaf.InstanceLocation) + ": " + leaf.Message
+		if !seen[msg] {
+			seen[msg] = true
+			schemaErr.Errors = append(schemaErr.Errors, msg)
+		}
+	}
+	sort.Strings(schemaErr.Errors)
+	return schemaErr
+}
+
+// validationLeaves returns the innermost causes of ve, which name the failing values.
+func validationLeaves(ve *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(ve.Causes) == 0 {
+		return []*jsonschema.ValidationError{ve}
+	}
+	var leaves []*jsonschema.ValidationError
+	for _, c := range ve.Causes {
+		leaves = append(leaves, validationLeaves(c)...)
+	}
+	return leaves
+}
+
+// keyPath converts a JSON pointer like "/http/port" to a dot-separated key path; the root is "(root)".
+func keyPath(pointer string) string {
+	if pointer == "" || pointer == "/" {
+		return "(root)"
+	}
+	parts := strings.Split(strings.TrimPrefix(pointer, "/"), "/")
+	for i, p := range parts {
+		parts[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// withoutComments returns a copy of m without comment keys, also in maps nested in arrays.
+func withoutComments(m map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if strings.Contains(k, CommentSuffix) {
+			continue
+		}
+		out[k] = withoutCommentsIn(v)
+	}
+	return out
+}
+
+// withoutCommentsIn returns a copy of v without comment keys if it is a map or an array, otherwise v.
+func withoutCommentsIn(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return withoutComments(t)
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, e := range t {
+			out[i] = withoutCommentsIn(e)
+		}
+		return out
+	}
+	return v
+}
@@ -0,0 +1,380 @@
+package toml
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/c2pc/config-migrate/driver"
+	"github.com/golang-migrate/migrate/v4/database"
+)
+
+// Toml implements driver.Driver for TOML config files using github.com/BurntSushi/toml.
+// Tables become nested maps and arrays of tables become arrays of maps, so merger.Merge sees
+// the same shapes it gets from the JSON and YAML drivers.
+type Toml struct{}
+
+func init() {
+	config.Register("toml", &Toml{}, config.Settings{})
+}
+
+// New returns a database.Driver that uses the TOML driver with the given settings.
+func New(cfg config.Settings) database.Driver {
+	return config.New(&Toml{}, cfg)
+}
+
+// Unmarshal parses TOML bytes. When out is *map[string]interface{}, integers are normalized to int
+// and arrays of tables to []interface{} so that merger type checks match values from migrations.
+func (Toml) Unmarshal(data []byte, out interface{}) error {
+	if err := toml.Unmarshal(data, out); err != nil {
+		return err
+	}
+	if ptr, ok := out.(*map[string]interface{}); ok {
+		if *ptr == nil {
+			*ptr = map[string]interface{}{}
+		}
+		normalize(*ptr)
+	}
+	return nil
+}
+
+// normalize converts decoder-specific types (int64, []map[string]interface{}) to the generic ones in place.
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			t[k] = normalize(val)
+		}
+		return t
+	case []map[string]interface{}:
+		out := make([]interface{}, len(t))
+		for i := range t {
+			out[i] = normalize(t[i])
+		}
+		return out
+	case []interface{}:
+		for i := range t {
+			t[i] = normalize(t[i])
+		}
+		return t
+	case int64:
+		return int(t)
+	}
+	return v
+}
+
+// Marshal serializes the map to TOML. Keys are sorted; plain values of a table come first, followed by
+// its sub-tables and arrays of tables. If replaceComments is true, keys ending with config.CommentSuffix
+// are written as '#' comments above the key or table they belong to; an empty comment becomes a blank line.
+func (Toml) Marshal(i interface{}, replaceComments bool) ([]byte, error) {
+	m, ok := i.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("toml: expected map[string]interface{}")
+	}
+
+	e := &encoder{replaceComments: replaceComments}
+	if err := e.writeTable(nil, m); err != nil {
+		return nil, err
+	}
+	return e.buf.Bytes(), nil
+}
+
+type encoder struct {
+	buf             bytes.Buffer
+	replaceComments bool
+}
+
+// writeTable writes the key/value pairs of m followed by its sub-tables. path is the table's full key path.
+func (e *encoder) writeTable(path []string, m map[string]interface{}) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		if e.replaceComments && isCommentKey(k) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var tables []string
+	for _, k := range keys {
+		v := m[k]
+		if v == nil {
+			continue
+		}
+		if _, ok := v.(map[string]interface{}); ok {
+			tables = append(tables, k)
+			continue
+		}
+		if _, ok := tableArray(v); ok {
+			tables = append(tables, k)
+			continue
+		}
+		value, err := formatValue(v)
+		if err != nil {
+			return err
+		}
+		e.writeComments(m, k, "")
+		e.buf.WriteString(formatKey(k) + " = " + value + "\n")
+	}
+
+	for _, k := range tables {
+		childPath := append(append([]string{}, path...), k)
+		header := formatPath(childPath)
+		if child, ok := m[k].(map[string]interface{}); ok {
+			e.writeComments(m, k, "\n")
+			e.buf.WriteString("[" + header + "]\n")
+			if err := e.writeTable(childPath, child); err != nil {
+				return err
+			}
+			continue
+		}
+		arr, _ := tableArray(m[k])
+		for idx, child := range arr {
+			if idx == 0 {
+				e.writeComments(m, k, "\n")
+			} else if e.buf.Len() > 0 {
+				e.buf.WriteString("\n")
+			}
+			e.buf.WriteString("[[" + header + "]]\n")
+			if err := e.writeTable(childPath, child); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// writeComments writes the comments attached to key in m. sep is written first when the buffer is not empty
+// (tables are separated from the preceding content by a blank line) unless the first comment is already empty.
+func (e *encoder) writeComments(m map[string]interface{}, key, sep string) {
+	var comments []string
+	if e.replaceComments {
+		comments = commentsFor(m, key)
+	}
+	if sep != "" && e.buf.Len() > 0 && (len(comments) == 0 || comments[0] != "") {
+		e.buf.WriteString(sep)
+	}
+	for _, comment := range comments {
+		if comment == "" {
+			e.buf.WriteString("\n")
+			continue
+		}
+		e.buf.WriteString("# " + comment + "\n")
+	}
+}
+
+// isCommentKey reports whether k is a comment-like key (ends with config.CommentSuffix).
+func isCommentKey(k string) bool {
+	return strings.HasSuffix(k, config.CommentSuffix) && commentTarget(k) != ""
+}
+
+// commentTarget returns the key a comment key belongs to: "host______" and "host_______" both belong to "host".
+func commentTarget(k string) string {
+	return strings.TrimRight(strings.TrimSuffix(k, config.CommentSuffix), "_")
+}
+
+// commentsFor returns the comments for key in m, ordered the same way as the comment keys are ordered in YAML output.
+func commentsFor(m map[string]interface{}, key string) []string {
+	var keys []string
+	for k := range m {
+		if isCommentKey(k) && commentTarget(k) == key {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	comments := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if m[k] == nil {
+			comments = append(comments, "")
+			continue
+		}
+		comments = append(comments, strings.TrimSpace(fmt.Sprint(m[k])))
+	}
+	return comments
+}
+
+// tableArray returns v as an array of tables when it is a non-empty slice whose elements are all maps.
+func tableArray(v interface{}) ([]map[string]interface{}, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice || rv.Len() == 0 {
+		return nil, false
+	}
+	out := make([]map[string]interface{}, 0, rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		m, ok := rv.Index(i).Interface().(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		out = append(out, m)
+	}
+	return out, true
+}
+
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func formatKey(k string) string {
+	if bareKey.MatchString(k) {
+		return k
+	}
+	return quote(k)
+}
+
+func formatPath(path []string) string {
+	parts := make([]string, len(path))
+	for i, k := range path {
+		parts[i] = formatKey(k)
+	}
+	return strings.Join(parts, ".")
+}
+
+// formatValue returns the inline TOML representation of v.
+func formatValue(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return quote(t), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(t), nil
+	case float32:
+		return formatFloat(float64(t)), nil
+	case float64:
+		return formatFloat(t), nil
+	case time.Time:
+		return formatTime(t), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			if t[k] != nil {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		parts := make([]string, 0, len(keys))
+		for _, k := range keys {
+			value, err := formatValue(t[k])
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, formatKey(k)+" = "+value)
+		}
+		if len(parts) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", nil
+	}
+
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array {
+		parts := make([]string, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			elem := rv.Index(i).Interface()
+			if elem == nil {
+				return "", fmt.Errorf("toml: cannot encode nil array element")
+			}
+			value, err := formatValue(elem)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, value)
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	}
+
+	return "", fmt.Errorf("toml: unsupported value type %T", v)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if !strings.ContainsAny(s, ".eE") {
+		s += ".0"
+	}
+	return s
+}
+
+// formatTime keeps the local date/time flavours that the decoder marks with "*-local" locations.
+func formatTime(t time.Time) string {
+	switch t.Location().String() {
+	case "date-local":
+		return t.Format("2006-01-02")
+	case "time-local":
+		return t.Format("15:04:05.999999999")
+	case "datetime-local":
+		return t.Format("2006-01-02T15:04:05.999999999")
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// quote returns s as a TOML basic string.
+func quote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+type version struct {
+	Version int  `toml:"version"`
+	Force   bool `toml:"force"`
+}
+
+// Version reads the top-level version and force keys.
+func (m Toml) Version(data []byte) (int, bool, error) {
+	v := new(version)
+	if err := m.Unmarshal(data, v); err != nil {
+		return 0, false, err
+	}
+
+	return v.Version, v.Force, nil
+}
+
+// EmptyData returns an empty TOML document.
+func (Toml) EmptyData() []byte {
+	return []byte{}
+}
+
+// Verbatim reports that Marshal output is written as is: strings are quoted, so quotes and "null" are part of the values.
+func (Toml) Verbatim() bool {
+	return true
+}
@@ -0,0 +1,487 @@
+package toml
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/c2pc/config-migrate/driver"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+const configPath = "./examples/config.toml"
+const migrationsPath = "./examples/migrations"
+
+func getConfig() database.Driver {
+	return New(config.Settings{
+		Path:                    configPath,
+		Perm:                    0777,
+		UnableToReplaceComments: true,
+	})
+}
+
+func getSourceURL() string {
+	return fmt.Sprintf("file://%s", migrationsPath)
+}
+
+func readConfigFile(path string) (map[string]interface{}, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, config.DefaultPerm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fileData, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	fileMap := map[string]interface{}{}
+	if err := (Toml{}).Unmarshal(fileData, &fileMap); err != nil {
+		return nil, err
+	}
+
+	return fileMap, nil
+}
+
+func readConfigFileRaw(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+var expectedUp3 = map[string]interface{}{
+	"version": 3,
+	"force":   false,
+	"array": []interface{}{
+		map[string]interface{}{
+			"map_array_boolean": []interface{}{true, false, true},
+			"map_array_number":  []interface{}{1, 2, 3},
+			"map_array_str":     []interface{}{"str1", "str2", "str3"},
+			"map_boolean":       false,
+			"map_number":        2,
+			"map_str":           "map_str",
+		},
+		map[string]interface{}{
+			"map_array_boolean": []interface{}{false, true, false},
+			"map_array_number":  []interface{}{4, 5, 6},
+			"map_array_str":     []interface{}{"str4", "str5", "str6"},
+			"map_boolean":       false,
+			"map_number":        2,
+			"map_str":           "map_str2",
+		},
+	},
+	"array2": []interface{}{1, 2, 3},
+	"array3": []interface{}{"str1", "str2", "str3"},
+	"array4": []interface{}{true, false, true},
+}
+
+func TestNew(t *testing.T) {
+	var _ database.Driver
+	_ = New(config.Settings{})
+}
+
+func TestOpen(t *testing.T) {
+	d := New(config.Settings{})
+
+	_, err := d.Open("1http://foo.com")
+	if err == nil {
+		t.Fatal("expected an error when calling Open with invalid path")
+	}
+
+	_, err = d.Open(getSourceURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLock_Unlock(t *testing.T) {
+	defer os.Remove(configPath)
+
+	d := New(config.Settings{})
+
+	_, err := d.Open("toml://" + configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readConfigFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLock_Close(t *testing.T) {
+	defer os.Remove(configPath)
+
+	d := New(config.Settings{Path: configPath})
+
+	if err := d.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUp1(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "toml", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := map[string]interface{}{
+		"version": 1,
+		"force":   false,
+		"str":     "str",
+		"number":  1,
+		"boolean": true,
+		"host": map[string]interface{}{
+			"url":  "url",
+			"host": "host10",
+		},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, result)
+	}
+
+	raw, err := readConfigFileRaw(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, comment := range []string{"# Str comment", "# HOST1\n# HOST2\n[host]", "# HOST8\n# HOST9\nhost = "} {
+		if !strings.Contains(raw, comment) {
+			t.Errorf("Expected comment %q in:\n%s", comment, raw)
+		}
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 1 {
+		t.Errorf("Expected: %d, got: %d", 1, v)
+	}
+
+	if f != false {
+		t.Errorf("Expected: %t, got: %t", false, f)
+	}
+}
+
+func TestUp2(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "toml", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := map[string]interface{}{
+		"version": 2,
+		"force":   false,
+		"str":     "str",
+		"number":  1,
+		"boolean": true,
+		"map": map[string]interface{}{
+			"map_str":     "map_str",
+			"map_number":  2,
+			"map_boolean": false,
+		},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, result)
+	}
+}
+
+func TestUp3(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "toml", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(3); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !reflect.DeepEqual(result, expectedUp3) {
+		t.Errorf("Expected:\n %v, got:\n %v", expectedUp3, result)
+	}
+
+	raw, err := readConfigFileRaw(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !strings.Contains(raw, "# Some comments\narray4 = [true, false, true]") {
+		t.Errorf("Expected array4 comment in:\n%s", raw)
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 3 {
+		t.Errorf("Expected: %d, got: %d", 3, v)
+	}
+
+	if f != false {
+		t.Errorf("Expected: %t, got: %t", false, f)
+	}
+}
+
+func TestUp3_Invalid_Config_File(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "toml", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = func() error {
+		f, err := os.OpenFile(configPath, os.O_WRONLY, config.DefaultPerm)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := f.Write([]byte("\ninvalid string\n")); err != nil {
+			return err
+		}
+		return nil
+	}()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err == nil {
+		t.Error("expected error")
+		return
+	}
+}
+
+func TestUp4_Invalid_Migration_File(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "toml", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(4); err == nil {
+		t.Error("expected error")
+		return
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 4 {
+		t.Errorf("Expected: %d, got: %d", 4, v)
+	}
+
+	if f != true {
+		t.Errorf("Expected: %t, got: %t", true, f)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "toml", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Drop(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(result) != 0 {
+		t.Errorf("Expected empty config after Drop, got: %v", result)
+	}
+}
+
+func TestUp_KeepsQuotesAndNull(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0777); err != nil {
+		t.Fatal(err)
+	}
+	migration := "name = \"O'Brien\"\nlog = \"/dev/null\"\n\n[db]\ndsn = \"user='app'\"\n"
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.up.toml"), []byte(migration), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.down.toml"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("log = \"/var/log/nullable.log\"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := migrate.NewWithDatabaseInstance("file://"+filepath.ToSlash(migrations), "toml", New(config.Settings{Path: path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"version": 1,
+		"force":   false,
+		"name":    "O'Brien",
+		"log":     "/var/log/nullable.log",
+		"db":      map[string]interface{}{"dsn": "user='app'"},
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, data)
+	}
+}
+
+func TestMarshal_RoundTrip(t *testing.T) {
+	in := map[string]interface{}{
+		"title": "a \"quoted\" value",
+		"ratio": 1.0,
+		"db": map[string]interface{}{
+			"dsn":   "postgres://localhost",
+			"pool":  map[string]interface{}{"max": 10},
+			"hosts": []interface{}{"a", "b"},
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"name": "alpha", "port": 80},
+			map[string]interface{}{"name": "beta", "port": 81, "tls": map[string]interface{}{"enabled": true}},
+		},
+		"matrix":    []interface{}{[]interface{}{1, 2}, []interface{}{map[string]interface{}{"x": 1}}},
+		"key.with":  "dots",
+		"skipped":   nil,
+		"empty_map": map[string]interface{}{},
+	}
+
+	b, err := (Toml{}).Marshal(in, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := map[string]interface{}{}
+	if err := (Toml{}).Unmarshal(b, &out); err != nil {
+		t.Fatalf("unmarshal: %v\n%s", err, b)
+	}
+
+	delete(in, "skipped")
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Expected: %v, got: %v\n%s", in, out, b)
+	}
+}
+
+func TestMarshal_KeepsCommentKeys(t *testing.T) {
+	in := map[string]interface{}{
+		"port" + config.CommentSuffix: "The port",
+		"port":                        8080,
+	}
+
+	b, err := (Toml{}).Marshal(in, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := map[string]interface{}{}
+	if err := (Toml{}).Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Expected: %v, got: %v", in, out)
+	}
+}
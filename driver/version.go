@@ -0,0 +1,221 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/pkg/errors"
+)
+
+// DefaultVersionKey and DefaultForceKey are the keys the migration state is stored under inside the config file.
+const (
+	DefaultVersionKey = "version"
+	DefaultForceKey   = "force"
+)
+
+// versionFileData is the content of the sidecar version file.
+type versionFileData struct {
+	Version int  `json:"version"`
+	Force   bool `json:"force"`
+}
+
+// usesDefaultKeys reports whether the state is stored in the top-level version/force keys read by Driver.Version.
+func (m *Config) usesDefaultKeys() bool {
+	return m.versionFile == "" && m.versionKey == DefaultVersionKey && m.forceKey == DefaultForceKey
+}
+
+// stateKeys returns the key paths the migration state occupies inside the config file.
+func (m *Config) stateKeys() []string {
+	if m.versionFile != "" {
+		return nil
+	}
+	return []string{m.versionKey, m.forceKey}
+}
+
+// readVersion returns the version and dirty flag stored for the config with content fileData.
+// It returns database.NilVersion when no version is stored.
+func (m *Config) readVersion(fileData []byte) (int, bool, error) {
+	if m.versionFile != "" && m.dryRun != nil && (m.dryRun.version != nil || m.dryRun.dropVersion) {
+		v := m.dryRun.version
+		if v == nil || v.Version == 0 {
+			return database.NilVersion, false, nil
+		}
+		return v.Version, v.Force, nil
+	}
+
+	if m.versionFile != "" {
+		data, err := os.ReadFile(m.versionFile)
+		if os.IsNotExist(err) || (err == nil && len(data) == 0) {
+			return database.NilVersion, false, nil
+		}
+		if err != nil {
+			return 0, false, err
+		}
+
+		v := versionFileData{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return 0, false, errors.Wrapf(err, "failed to parse %s", m.versionFile)
+		}
+		if v.Version == 0 {
+			return database.NilVersion, false, nil
+		}
+		return v.Version, v.Force, nil
+	}
+
+	if len(fileData) == 0 {
+		return database.NilVersion, false, nil
+	}
+
+	var version int
+	var force bool
+	if m.usesDefaultKeys() {
+		var err error
+		version, force, err = m.driver.Version(fileData)
+		if err != nil {
+			return 0, false, err
+		}
+	} else {
+		fileMap := map[string]interface{}{}
+		if err := m.driver.Unmarshal(fileData, &fileMap); err != nil {
+			return 0, false, err
+		}
+		if v, ok := getValueByPath(fileMap, m.versionKey); ok {
+			version = toInt(v)
+		}
+		if v, ok := getValueByPath(fileMap, m.forceKey); ok {
+			force = toBool(v)
+		}
+	}
+
+	if version == 0 {
+		return database.NilVersion, false, nil
+	}
+	return version, force, nil
+}
+
+// writeVersion stores version and dirty either in fileMap or in the sidecar version file.
+// It reports whether fileMap was changed.
+func (m *Config) writeVersion(fileMap map[string]interface{}, version int, dirty bool) (bool, error) {
+	if m.versionFile != "" && m.dryRun != nil {
+		m.dryRun.version = &versionFileData{Version: version, Force: dirty}
+		return false, nil
+	}
+
+	if m.versionFile != "" {
+		data, err := json.MarshalIndent(versionFileData{Version: version, Force: dirty}, "", "    ")
+		if err != nil {
+			return false, err
+		}
+		return false, WriteFileAtomic(m.versionFile, data, m.perm)
+	}
+
+	setValueByPath(fileMap, m.versionKey, version)
+	setValueByPath(fileMap, m.forceKey, dirty)
+	return true, nil
+}
+
+// dropVersion removes the sidecar version file, if any.
+func (m *Config) dropVersion() error {
+	if m.versionFile == "" {
+		return nil
+	}
+	if m.dryRun != nil {
+		m.dryRun.version = nil
+		m.dryRun.dropVersion = true
+		return nil
+	}
+	if err := os.Remove(m.versionFile); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// forceKeyFor returns the force key next to versionKey: "_meta.schema_version" → "_meta.force".
+func forceKeyFor(versionKey string) string {
+	if i := strings.LastIndex(versionKey, "."); i >= 0 {
+		return versionKey[:i+1] + DefaultForceKey
+	}
+	return DefaultForceKey
+}
+
+// getValueByPath returns value at dot-separated path (e.g. "_meta.schema_version") in m.
+func getValueByPath(m map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = m
+	for _, key := range strings.Split(path, ".") {
+		mp, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = mp[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// setValueByPath sets value at dot-separated path in m, creating nested maps as needed.
+func setValueByPath(m map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	current := m
+	for _, key := range parts[:len(parts)-1] {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			current[key] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+}
+
+// deleteValueByPath removes the key at dot-separated path from m and returns the removed value.
+func deleteValueByPath(m map[string]interface{}, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	parent := m
+	if len(parts) > 1 {
+		v, ok := getValueByPath(m, strings.Join(parts[:len(parts)-1], "."))
+		if !ok {
+			return nil, false
+		}
+		if parent, ok = v.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	key := parts[len(parts)-1]
+	v, ok := parent[key]
+	delete(parent, key)
+	return v, ok
+}
+
+// toInt converts a version value decoded by any driver (int, float64, string for INI) to int.
+func toInt(v interface{}) int {
+	switch t := v.(type) {
+	case int:
+		return t
+	case int64:
+		return int(t)
+	case float64:
+		return int(t)
+	case string:
+		n, _ := strconv.Atoi(strings.TrimSpace(t))
+		return n
+	}
+	n, _ := strconv.Atoi(fmt.Sprint(v))
+	return n
+}
+
+// toBool converts a force value decoded by any driver (bool, string for INI) to bool.
+func toBool(v interface{}) bool {
+	switch t := v.(type) {
+	case bool:
+		return t
+	case string:
+		s := strings.TrimSpace(t)
+		return strings.EqualFold(s, "true") || strings.EqualFold(s, "yes") || s == "1"
+	}
+	return false
+}
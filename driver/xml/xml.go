@@ -0,0 +1,452 @@
+package xml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/c2pc/config-migrate/driver"
+	"github.com/golang-migrate/migrate/v4/database"
+)
+
+const (
+	// DefaultRoot is the name of the root element written by Marshal if Xml.Root is empty.
+	DefaultRoot = "config"
+
+	// AttrPrefix marks keys that map to attributes: "@port" is the attribute port of the element.
+	AttrPrefix = "@"
+
+	// TextKey is the key of the text content of an element that also has attributes or child elements.
+	TextKey = "#text"
+)
+
+// rootKey holds the name of the root element read by Unmarshal, and orderKey the names of the child elements of an
+// element in the order they first appear. They end with config.CommentSuffix so that diffs and schema validation skip
+// them like comments.
+const (
+	rootKey  = "#root______"
+	orderKey = "#order______"
+)
+
+// Xml implements driver.Driver for XML config files. The children of the root element become the keys of the map:
+//   - an element with only text becomes a string: <port>80</port> → "port": "80";
+//   - an element with attributes or child elements becomes a map; attributes are keys prefixed with AttrPrefix
+//     and its text is stored under TextKey: <db driver="pg">dsn</db> → "db": {"@driver": "pg", "#text": "dsn"};
+//   - repeated elements become an array: <host>a</host><host>b</host> → "host": ["a", "b"].
+//
+// All values are strings. Attributes of the root element are keys of the map too, so version and force may be
+// stored as child elements (the default) or as root attributes with Settings.VersionKey "@version".
+// The name of the root element and the order of child elements are kept, so Marshal writes them back as read.
+type Xml struct {
+	// Root — name of the root element written by Marshal if the data was not read from a document, e.g. for a new
+	// file without a root element in the migration; DefaultRoot if empty. Unmarshal accepts any root element.
+	Root string
+}
+
+func init() {
+	config.Register("xml", &Xml{}, config.Settings{})
+}
+
+// New returns a database.Driver that uses the XML driver with the given settings.
+func New(cfg config.Settings) database.Driver {
+	return config.New(&Xml{}, cfg)
+}
+
+// Unmarshal parses the XML document into a map of the children and attributes of its root element.
+// Comments, processing instructions and whitespace between elements are ignored.
+func (x Xml) Unmarshal(data []byte, out interface{}) error {
+	ptr, ok := out.(*map[string]interface{})
+	if !ok {
+		return fmt.Errorf("xml: out must be *map[string]interface{}")
+	}
+
+	m := map[string]interface{}{}
+	if len(bytes.TrimSpace(data)) == 0 {
+		*ptr = m
+		return nil
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	root := false
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("xml: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if root {
+				return fmt.Errorf("xml: more than one root element")
+			}
+			root = true
+			v, err := decodeElement(d, t)
+			if err != nil {
+				return err
+			}
+			if child, ok := v.(map[string]interface{}); ok {
+				m = child
+			}
+			m[rootKey] = name(t.Name)
+		case xml.CharData:
+			if len(bytes.TrimSpace(t)) > 0 {
+				return fmt.Errorf("xml: text outside of the root element")
+			}
+		}
+	}
+
+	*ptr = m
+	return nil
+}
+
+// decodeElement decodes the element started by start up to its end element.
+func decodeElement(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	m := map[string]interface{}{}
+	for _, attr := range start.Attr {
+		m[AttrPrefix+name(attr.Name)] = attr.Value
+	}
+
+	var text strings.Builder
+	var order []interface{}
+	for {
+		tok, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("unexpected EOF in element <%s>", name(start.Name))
+			}
+			return nil, fmt.Errorf("xml: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			v, err := decodeElement(d, t)
+			if err != nil {
+				return nil, err
+			}
+			k := name(t.Name)
+			switch prev := m[k].(type) {
+			case nil:
+				m[k] = v
+				order = append(order, k)
+			case []interface{}:
+				m[k] = append(prev, v)
+			default:
+				m[k] = []interface{}{prev, v}
+			}
+		case xml.EndElement:
+			if name(t.Name) != name(start.Name) {
+				return nil, fmt.Errorf("xml: element <%s> closed by </%s>", name(start.Name), name(t.Name))
+			}
+			s := strings.TrimSpace(text.String())
+			if len(m) == 0 {
+				return s, nil
+			}
+			if s != "" {
+				m[TextKey] = s
+			}
+			if len(order) > 1 {
+				m[orderKey] = order
+			}
+			return m, nil
+		case xml.CharData:
+			text.Write(t)
+		}
+	}
+}
+
+// name returns the name with its namespace prefix as written in the document: "xsi:type".
+func name(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// Marshal serializes the map as the children and attributes of the root element. The root element and child
+// elements read by Unmarshal keep their name and order; other child elements follow in key order. Attributes come
+// first, sorted. If replaceComments is true, keys ending with config.CommentSuffix are written as <!-- --> comments
+// above the element they belong to; an empty comment becomes a blank line.
+func (x Xml) Marshal(i interface{}, replaceComments bool) ([]byte, error) {
+	m, ok := i.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("xml: expected map[string]interface{}")
+	}
+
+	root, _ := m[rootKey].(string)
+	if root == "" {
+		root = x.root()
+	}
+
+	w := &writer{replaceComments: replaceComments}
+	w.buf.WriteString(xml.Header)
+	w.element(root, m, 0)
+	return w.buf.Bytes(), nil
+}
+
+type writer struct {
+	buf             bytes.Buffer
+	replaceComments bool
+}
+
+// element writes value as the element k.
+func (w *writer) element(k string, value interface{}, depth int) {
+	indent := strings.Repeat("  ", depth)
+
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			w.element(k, item, depth)
+		}
+		return
+	case map[string]interface{}:
+		w.buf.WriteString(indent + "<" + k)
+
+		var attrs, children []string
+		for ck := range v {
+			switch {
+			case strings.HasPrefix(ck, AttrPrefix):
+				attrs = append(attrs, ck)
+			case ck == TextKey, ck == rootKey, ck == orderKey:
+			case w.replaceComments && isCommentKey(ck):
+			default:
+				children = append(children, ck)
+			}
+		}
+		sort.Strings(attrs)
+		children = ordered(children, v[orderKey])
+
+		for _, a := range attrs {
+			w.buf.WriteString(" " + strings.TrimPrefix(a, AttrPrefix) + `="` + escapeAttr(format(v[a])) + `"`)
+		}
+
+		text := format(v[TextKey])
+		if len(children) == 0 {
+			if text == "" {
+				w.buf.WriteString("/>\n")
+				return
+			}
+			w.buf.WriteString(">" + escapeText(text) + "</" + k + ">\n")
+			return
+		}
+
+		w.buf.WriteString(">\n")
+		if text != "" {
+			w.buf.WriteString(indent + "  " + escapeText(text) + "\n")
+		}
+		for _, ck := range children {
+			if w.replaceComments {
+				w.comments(v, ck, depth+1)
+			}
+			w.element(ck, v[ck], depth+1)
+		}
+		w.buf.WriteString(indent + "</" + k + ">\n")
+	default:
+		s := format(v)
+		if s == "" {
+			w.buf.WriteString(indent + "<" + k + "/>\n")
+			return
+		}
+		w.buf.WriteString(indent + "<" + k + ">" + escapeText(s) + "</" + k + ">\n")
+	}
+}
+
+// root returns the name of the root element of data not read from a document.
+func (x Xml) root() string {
+	if x.Root == "" {
+		return DefaultRoot
+	}
+	return x.Root
+}
+
+// Align adds the child elements of the migration that are missing from the order of the live document next to their
+// neighbours in the migration, so that new elements are not moved to the end. A live document with the default root
+// element, such as a new file holding only the version, takes the root element of the migration.
+func (x Xml) Align(migration, current map[string]interface{}) map[string]interface{} {
+	if root, ok := migration[rootKey].(string); ok && current[rootKey] == x.root() {
+		current[rootKey] = root
+	}
+	alignOrder(migration, current)
+	return current
+}
+
+func alignOrder(migration, current map[string]interface{}) {
+	for k, v := range migration {
+		if mChild, ok := v.(map[string]interface{}); ok {
+			if cChild, ok := current[k].(map[string]interface{}); ok {
+				alignOrder(mChild, cChild)
+			}
+		}
+	}
+
+	names, _ := migration[orderKey].([]interface{})
+	cur, _ := current[orderKey].([]interface{})
+	if len(names) == 0 || len(cur) == 0 {
+		return
+	}
+
+	out := append([]interface{}(nil), cur...)
+	for j, n := range names {
+		if indexOf(out, n) >= 0 {
+			continue
+		}
+		// Before the next element the document has, or else after the one preceding it in the migration
+		at := -1
+		for _, next := range names[j+1:] {
+			if i := indexOf(out, next); i >= 0 {
+				at = i
+				break
+			}
+		}
+		if at < 0 {
+			at = len(out)
+			if j > 0 {
+				at = indexOf(out, names[j-1]) + 1
+			}
+		}
+		out = append(out[:at], append([]interface{}{n}, out[at:]...)...)
+	}
+	current[orderKey] = out
+}
+
+func indexOf(arr []interface{}, v interface{}) int {
+	for i, e := range arr {
+		if e == v {
+			return i
+		}
+	}
+	return -1
+}
+
+// ordered returns the children in the order of the recorded names; the others follow in key order.
+func ordered(children []string, order interface{}) []string {
+	sort.Strings(children)
+	names, _ := order.([]interface{})
+	if len(names) == 0 {
+		return children
+	}
+
+	present := make(map[string]bool, len(children))
+	for _, k := range children {
+		present[k] = true
+	}
+	out := make([]string, 0, len(children))
+	for _, n := range names {
+		k, ok := n.(string)
+		if !ok || !present[k] {
+			continue
+		}
+		delete(present, k)
+		out = append(out, k)
+	}
+	for _, k := range children {
+		if present[k] {
+			out = append(out, k)
+		}
+	}
+	return out
+}
+
+// comments writes the comments of the element k of m.
+func (w *writer) comments(m map[string]interface{}, k string, depth int) {
+	for _, comment := range commentsFor(m, k) {
+		if comment == "" {
+			w.buf.WriteString("\n")
+			continue
+		}
+		// "--" is not allowed inside XML comments
+		for strings.Contains(comment, "--") {
+			comment = strings.ReplaceAll(comment, "--", "- -")
+		}
+		w.buf.WriteString(strings.Repeat("  ", depth) + "<!-- " + comment + " -->\n")
+	}
+}
+
+func format(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+func escapeAttr(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func isCommentKey(k string) bool {
+	return strings.HasSuffix(k, config.CommentSuffix) && commentTarget(k) != ""
+}
+
+// commentTarget returns the element a comment key belongs to: "host______" and "host_______" both belong to "host".
+func commentTarget(k string) string {
+	return strings.TrimRight(strings.TrimSuffix(k, config.CommentSuffix), "_")
+}
+
+// commentsFor returns the comments for the element k in m, ordered by comment key.
+func commentsFor(m map[string]interface{}, k string) []string {
+	var keys []string
+	for ck := range m {
+		if isCommentKey(ck) && commentTarget(ck) == k {
+			keys = append(keys, ck)
+		}
+	}
+	sort.Strings(keys)
+
+	comments := make([]string, 0, len(keys))
+	for _, ck := range keys {
+		comments = append(comments, strings.TrimSpace(format(m[ck])))
+	}
+	return comments
+}
+
+// Version reads version and force from child elements of the root element or, if missing, from its attributes.
+func (x Xml) Version(data []byte) (int, bool, error) {
+	var out map[string]interface{}
+	if err := x.Unmarshal(data, &out); err != nil {
+		return 0, false, err
+	}
+
+	version := 0
+	if s, ok := lookup(out, "version"); ok {
+		version, _ = strconv.Atoi(strings.TrimSpace(s))
+	}
+	force := false
+	if s, ok := lookup(out, "force"); ok {
+		force, _ = strconv.ParseBool(strings.TrimSpace(s))
+	}
+	return version, force, nil
+}
+
+// lookup returns the text of the child element k of m or of the attribute k.
+func lookup(m map[string]interface{}, k string) (string, bool) {
+	if s, ok := m[k].(string); ok {
+		return s, true
+	}
+	s, ok := m[AttrPrefix+k].(string)
+	return s, ok
+}
+
+// EmptyData returns an empty XML document.
+func (Xml) EmptyData() []byte {
+	return []byte{}
+}
+
+// Verbatim reports that Marshal output is written as is: text and attributes are escaped, so quotes and "null" are
+// part of the values.
+func (Xml) Verbatim() bool {
+	return true
+}
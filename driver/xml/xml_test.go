@@ -0,0 +1,537 @@
+package xml
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	config "github.com/c2pc/config-migrate/driver"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+const configPath = "./examples/config.xml"
+const migrationsPath = "./examples/migrations"
+
+func getConfig() database.Driver {
+	return New(config.Settings{
+		Path:                    configPath,
+		Perm:                    0777,
+		UnableToReplaceComments: true,
+	})
+}
+
+func getSourceURL() string {
+	return fmt.Sprintf("file://%s", migrationsPath)
+}
+
+func readConfigFile(path string) (map[string]interface{}, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY, config.DefaultPerm)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fileData, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	fileMap := map[string]interface{}{}
+	if err := (Xml{}).Unmarshal(fileData, &fileMap); err != nil {
+		return nil, err
+	}
+
+	return fileMap, nil
+}
+
+func TestNew(t *testing.T) {
+	var _ database.Driver
+	_ = New(config.Settings{})
+}
+
+func TestOpen(t *testing.T) {
+	xmlDriver := New(config.Settings{})
+
+	_, err := xmlDriver.Open("1http://foo.com")
+	if err == nil {
+		t.Fatal("expected an error when calling Open with invalid path")
+	}
+
+	_, err = xmlDriver.Open(getSourceURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLock_Unlock(t *testing.T) {
+	defer os.Remove(configPath)
+
+	xmlDriver := New(config.Settings{})
+
+	_, err := xmlDriver.Open("xml://" + configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := xmlDriver.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := xmlDriver.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readConfigFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUp1(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "xml", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<config>
+
+  <!-- HTTP server -->
+  <server scheme="http">
+    <port>8080</port>
+    <host>localhost</host>
+  </server>
+  <allowedOrigin>https://a.example.com</allowedOrigin>
+  <allowedOrigin>https://b.example.com</allowedOrigin>
+  <force>false</force>
+  <version>1</version>
+</config>
+`
+
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 1 {
+		t.Errorf("Expected version %d, got: %d", 1, v)
+	}
+
+	if f != false {
+		t.Errorf("Expected force %t, got: %t", false, f)
+	}
+}
+
+func TestUp2(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "xml", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// A value at the old path is moved by migration 2
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data = []byte(string(data[:len(data)-len("</config>\n")]) + "<database><dsn>Server=db;Database=prod</dsn></database></config>\n")
+	if err := os.WriteFile(configPath, data, 0777); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := map[string]interface{}{
+		"version": "2", "force": "false",
+		"server": map[string]interface{}{
+			"@scheme": "http",
+			"port":    "8443",
+			"host":    "localhost",
+			"timeout": map[string]interface{}{"@unit": "s", "#text": "30"},
+			orderKey:  []interface{}{"port", "host", "timeout"},
+		},
+		"allowedOrigin":    []interface{}{"https://a.example.com", "https://b.example.com"},
+		"connectionString": "Server=db;Database=prod",
+		rootKey:            "config",
+		orderKey:           []interface{}{"server", "allowedOrigin", "connectionString", "force", "version"},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, result)
+	}
+
+	if err := m.Steps(-1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	v, _, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 1 {
+		t.Errorf("Expected version %d, got: %d", 1, v)
+	}
+}
+
+func TestUp3_Invalid_Migration_File(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "xml", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(3); err == nil {
+		t.Error("expected error")
+		return
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 3 {
+		t.Errorf("Expected version %d, got: %d", 3, v)
+	}
+
+	if f != true {
+		t.Errorf("Expected force %t, got: %t", true, f)
+	}
+}
+
+func TestVersion_RootAttribute(t *testing.T) {
+	defer os.Remove(configPath)
+
+	d := New(config.Settings{
+		Path:       configPath,
+		Perm:       0777,
+		VersionKey: "@version",
+		ForceKey:   "@force",
+	})
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "xml", d)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	v, f, err := (Xml{}).Version(data)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 1 || f != false {
+		t.Errorf("Expected version 1 and force false, got: %d %t\n%s", v, f, data)
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if result["@version"] != "1" || result["@force"] != "false" {
+		t.Errorf("Expected version in root attribute, got: %v", result)
+	}
+}
+
+func TestUp_AppConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.config")
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	// The migration lists the elements in another order and adds an app setting
+	up := `<?xml version="1.0" encoding="utf-8"?>
+<configuration>
+  <appSettings>
+    <add key="LogLevel" value="Info"/>
+  </appSettings>
+  <configSections>
+    <section name="log4net" type="log4net.Config.Log4NetConfigurationSectionHandler, log4net"/>
+  </configSections>
+  <FeatureFlags enabled="false"/>
+  <startup>
+    <supportedRuntime version="v4.0" sku=".NETFramework,Version=v4.8"/>
+  </startup>
+</configuration>
+`
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.up.xml"), []byte(up), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.down.xml"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	current := `<?xml version="1.0" encoding="utf-8"?>
+<configuration>
+  <configSections>
+    <section name="log4net" type="log4net.Config.Log4NetConfigurationSectionHandler, log4net"/>
+  </configSections>
+  <startup>
+    <supportedRuntime version="v4.0" sku=".NETFramework,Version=v4.8"/>
+  </startup>
+  <appSettings>
+    <add key="LogLevel" value="Debug"/>
+  </appSettings>
+</configuration>
+`
+	if err := os.WriteFile(path, []byte(current), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new file takes the root element of the migration
+	d := New(config.Settings{Path: filepath.Join(dir, "new.config"), Perm: 0666})
+	m, err := migrate.NewWithDatabaseInstance("file://"+filepath.ToSlash(migrations), "xml", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	created, err := os.ReadFile(filepath.Join(dir, "new.config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(created), "<configuration>\n  <appSettings>") {
+		t.Errorf("Expected the root element and order of the migration, got:\n%s", created)
+	}
+
+	d = New(config.Settings{Path: path, VersionFile: path + ".migrate.json"})
+	m, err = migrate.NewWithDatabaseInstance("file://"+filepath.ToSlash(migrations), "xml", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<configuration>
+  <configSections>
+    <section name="log4net" type="log4net.Config.Log4NetConfigurationSectionHandler, log4net"/>
+  </configSections>
+  <FeatureFlags enabled="false"/>
+  <startup>
+    <supportedRuntime sku=".NETFramework,Version=v4.8" version="v4.0"/>
+  </startup>
+  <appSettings>
+    <add key="LogLevel" value="Debug"/>
+  </appSettings>
+</configuration>
+`
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
+
+func TestUp_KeepsQuotesAndNull(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.xml")
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0777); err != nil {
+		t.Fatal(err)
+	}
+	up := `<config>
+  <name>O'Brien</name>
+  <log path="/dev/null"/>
+</config>
+`
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.up.xml"), []byte(up), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.down.xml"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(config.Settings{Path: path, VersionFile: path + ".migrate.json"})
+	m, err := migrate.NewWithDatabaseInstance("file://"+filepath.ToSlash(migrations), "xml", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data["name"] != "O'Brien" || !reflect.DeepEqual(data["log"], map[string]interface{}{AttrPrefix + "path": "/dev/null"}) {
+		t.Errorf("Expected name O'Brien and log path /dev/null, got: %v", data)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	defer os.Remove(configPath)
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "xml", getConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Drop(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(result) != 0 {
+		t.Errorf("Expected empty config after Drop, got: %v", result)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	data := `<?xml version="1.0"?>
+<!-- comment -->
+<configuration xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">
+  <appSettings>
+    <add key="a" value="1"/>
+    <add key="b" value="2 &amp; 3"/>
+  </appSettings>
+  <name><![CDATA[<raw>]]></name>
+  <empty/>
+  <typed xsi:type="int">5</typed>
+</configuration>
+`
+
+	out := map[string]interface{}{}
+	if err := (Xml{}).Unmarshal([]byte(data), &out); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"@xmlns:xsi": "http://www.w3.org/2001/XMLSchema-instance",
+		"appSettings": map[string]interface{}{
+			"add": []interface{}{
+				map[string]interface{}{"@key": "a", "@value": "1"},
+				map[string]interface{}{"@key": "b", "@value": "2 & 3"},
+			},
+		},
+		"name":   "<raw>",
+		"empty":  "",
+		"typed":  map[string]interface{}{"@xsi:type": "int", "#text": "5"},
+		rootKey:  "configuration",
+		orderKey: []interface{}{"appSettings", "name", "empty", "typed"},
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("Expected: %v, got: %v", expected, out)
+	}
+
+	for _, invalid := range []string{"<a><b></a>", "<a/><b/>", "<a>"} {
+		if err := (Xml{}).Unmarshal([]byte(invalid), &out); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestMarshal_RoundTrip(t *testing.T) {
+	in := map[string]interface{}{
+		"@xmlns:xsi": "http://www.w3.org/2001/XMLSchema-instance",
+		"appSettings": map[string]interface{}{
+			"add": []interface{}{
+				map[string]interface{}{"@key": "a", "@value": "1"},
+				map[string]interface{}{"@key": "b", "@value": "\"2\" & <3>"},
+			},
+		},
+		"name":   "<raw> & more",
+		"empty":  "",
+		"typed":  map[string]interface{}{"@xsi:type": "int", "#text": "5"},
+		rootKey:  "configuration",
+		orderKey: []interface{}{"typed", "appSettings", "name", "empty"},
+	}
+
+	b, err := (Xml{}).Marshal(in, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := map[string]interface{}{}
+	if err := (Xml{}).Unmarshal(b, &out); err != nil {
+		t.Fatalf("unmarshal: %v\n%s", err, b)
+	}
+
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("Expected: %v, got: %v\n%s", in, out, b)
+	}
+}
@@ -0,0 +1,283 @@
+package yaml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/c2pc/config-migrate/driver"
+	"gopkg.in/yaml.v3"
+)
+
+// DocumentsKey holds the documents of a multi-document stream, keyed by selector or by index.
+// A migration addresses a document through it, e.g. "---.Deployment/web.spec.replicas".
+const DocumentsKey = "---"
+
+// orderKey holds the document keys in the order the documents appear in the stream.
+// It ends with config.CommentSuffix so that diffs and schema validation skip it like a comment.
+const orderKey = DocumentsKey + "______"
+
+// DefaultSelector identifies Kubernetes manifests by kind, namespace and name. A migration that changes
+// metadata.namespace therefore replaces the document with a new one: the values of the old document are not kept.
+var DefaultSelector = []string{"kind", "metadata.namespace", "metadata.name"}
+
+type document struct {
+	key   string
+	value interface{}
+}
+
+// decodeDocuments returns the non-empty documents of a YAML stream.
+func decodeDocuments(data []byte) ([]interface{}, error) {
+	var docs []interface{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc interface{}
+		if err := dec.Decode(&doc); err != nil {
+			if errors.Is(err, io.EOF) {
+				return docs, nil
+			}
+			return nil, err
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+}
+
+// documentKey joins the scalar values of the selector paths found in doc with "/", e.g. "Deployment/web".
+// A document without any of them is keyed by its index in the stream.
+func (m Yaml) documentKey(doc interface{}, index int) string {
+	selector := m.Selector
+	if selector == nil {
+		selector = DefaultSelector
+	}
+
+	var parts []string
+	if mp, ok := doc.(map[string]interface{}); ok {
+		for _, path := range selector {
+			v, ok := lookup(mp, path)
+			if !ok {
+				continue
+			}
+			switch v.(type) {
+			case nil, map[string]interface{}, []interface{}:
+				continue
+			}
+			parts = append(parts, fmt.Sprint(v))
+		}
+	}
+
+	if len(parts) == 0 {
+		return strconv.Itoa(index)
+	}
+	return strings.Join(parts, "/")
+}
+
+func lookup(m map[string]interface{}, path string) (interface{}, bool) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		m = next
+	}
+	v, ok := m[keys[len(keys)-1]]
+	return v, ok
+}
+
+// unmarshalDocuments maps a multi-document stream under DocumentsKey. version and force of the first
+// document are moved to the top level, where Config keeps the migration state.
+func (m Yaml) unmarshalDocuments(docs []interface{}) (map[string]interface{}, error) {
+	byKey := make(map[string]interface{}, len(docs))
+	order := make([]interface{}, 0, len(docs))
+	for n, doc := range docs {
+		key := m.documentKey(doc, n)
+		if _, ok := byKey[key]; ok {
+			return nil, fmt.Errorf("yaml: duplicate document %q", key)
+		}
+		byKey[key] = doc
+		order = append(order, key)
+	}
+
+	data := map[string]interface{}{DocumentsKey: byKey, orderKey: order}
+	if first, ok := docs[0].(map[string]interface{}); ok {
+		for _, k := range []string{config.DefaultVersionKey, config.DefaultForceKey} {
+			if v, ok := first[k]; ok {
+				data[k] = v
+				delete(first, k)
+			}
+		}
+	}
+	return data, nil
+}
+
+// Align puts a single live document under DocumentsKey when the migration has several documents, so that its
+// values are merged with the document of the migration that has the same key.
+func (m Yaml) Align(migration, current map[string]interface{}) map[string]interface{} {
+	if _, ok := isMultiDocument(migration); !ok || len(current) == 0 {
+		return current
+	}
+	if _, ok := isMultiDocument(current); ok {
+		return current
+	}
+	doc := make(map[string]interface{}, len(current))
+	for k, v := range current {
+		doc[k] = v
+	}
+	data, err := m.unmarshalDocuments([]interface{}{doc})
+	if err != nil || len(doc) == 0 {
+		// Only version and force, e.g. a new file
+		return current
+	}
+	return data
+}
+
+// orderedDocuments returns the documents of data in stream order. Documents missing from the recorded
+// order, such as ones added by a migration, follow in key order. Top-level keys other than DocumentsKey
+// are written into the first document.
+func orderedDocuments(data map[string]interface{}) []document {
+	byKey, _ := data[DocumentsKey].(map[string]interface{})
+	order, _ := data[orderKey].([]interface{})
+
+	seen := make(map[string]bool, len(byKey))
+	keys := make([]string, 0, len(byKey))
+	for _, k := range order {
+		key, ok := k.(string)
+		if _, exists := byKey[key]; !ok || !exists || seen[key] {
+			continue
+		}
+		seen[key] = true
+		keys = append(keys, key)
+	}
+
+	var rest []string
+	for key := range byKey {
+		if !seen[key] {
+			rest = append(rest, key)
+		}
+	}
+	sort.Slice(rest, func(i, j int) bool {
+		a, errA := strconv.Atoi(rest[i])
+		b, errB := strconv.Atoi(rest[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		if (errA == nil) != (errB == nil) {
+			return errA == nil
+		}
+		return rest[i] < rest[j]
+	})
+	keys = append(keys, rest...)
+
+	top := map[string]interface{}{}
+	for k, v := range data {
+		if k != DocumentsKey && k != orderKey {
+			top[k] = v
+		}
+	}
+
+	docs := make([]document, 0, len(keys)+1)
+	for _, key := range keys {
+		docs = append(docs, document{key: key, value: byKey[key]})
+	}
+	if len(top) == 0 {
+		return docs
+	}
+	if len(docs) == 0 {
+		return []document{{key: "0", value: top}}
+	}
+	if first, ok := docs[0].value.(map[string]interface{}); ok {
+		merged := make(map[string]interface{}, len(first)+len(top))
+		for k, v := range first {
+			merged[k] = v
+		}
+		for k, v := range top {
+			merged[k] = v
+		}
+		docs[0].value = merged
+	}
+	return docs
+}
+
+// isMultiDocument reports whether data was read from a multi-document stream.
+func isMultiDocument(i interface{}) (map[string]interface{}, bool) {
+	data, ok := i.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	_, ok = data[DocumentsKey].(map[string]interface{})
+	return data, ok
+}
+
+// marshalDocuments writes every document in order, separated by "---".
+func (m Yaml) marshalDocuments(data map[string]interface{}, replaceComments bool) ([]byte, error) {
+	var buf bytes.Buffer
+	for n, doc := range orderedDocuments(data) {
+		b, err := m.marshalDocument(doc.value, replaceComments)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(b)
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// patchDocuments patches every document of the original stream that is still present in data and
+// serializes the others with Marshal.
+func (m Yaml) patchDocuments(original []byte, data map[string]interface{}, replaceComments bool) ([]byte, error) {
+	nodes := map[string]*yaml.Node{}
+	dec := yaml.NewDecoder(bytes.NewReader(original))
+	for n := 0; ; {
+		var node yaml.Node
+		if err := dec.Decode(&node); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+		var doc interface{}
+		if err := node.Decode(&doc); err != nil {
+			return nil, err
+		}
+		if doc == nil {
+			continue
+		}
+		nodes[m.documentKey(doc, n)] = &node
+		n++
+	}
+
+	lines := strings.Split(string(original), "\n")
+
+	var buf bytes.Buffer
+	for n, doc := range orderedDocuments(data) {
+		var b []byte
+		var err error
+		if node, ok := nodes[doc.key]; ok {
+			b, err = m.patchDocument(node, lines, doc.value, replaceComments)
+		} else {
+			b, err = m.marshalDocument(doc.value, replaceComments)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			buf.WriteString("---\n")
+		}
+		buf.Write(b)
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			buf.WriteString("\n")
+		}
+	}
+	return buf.Bytes(), nil
+}
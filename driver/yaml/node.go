@@ -0,0 +1,341 @@
+package yaml
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/c2pc/config-migrate/driver"
+	"gopkg.in/yaml.v3"
+)
+
+// blankMarker is a temporary head comment standing for a blank line of the original document.
+// yaml.v3 drops blank lines on encode, so they are carried through as comments and restored afterwards.
+const blankMarker = "#config-migrate:blank"
+
+// defaultIndent matches the indentation of yaml.Marshal.
+const defaultIndent = 4
+
+// Patch implements config.Patcher. The original document is parsed into a yaml.Node tree and the merged
+// data is applied onto it: untouched keys keep their order, comments, style, anchors and the blank lines
+// before them, removed keys are dropped and new keys are appended in sorted order.
+// If replaceComments is true, config.CommentSuffix keys become head comments of their target key
+// unless that key already has a hand-written comment.
+// Documents that are empty or not a mapping are serialized with Marshal.
+// Multi-document streams are patched document by document.
+func (m Yaml) Patch(original []byte, i interface{}, replaceComments bool) ([]byte, error) {
+	if data, ok := isMultiDocument(i); ok {
+		return m.patchDocuments(original, data, replaceComments)
+	}
+
+	data, ok := i.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("yaml: expected map[string]interface{}")
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(original, &doc); err != nil {
+		return nil, err
+	}
+	return m.patchDocument(&doc, strings.Split(string(original), "\n"), data, replaceComments)
+}
+
+// patchDocument applies data onto a single document node. lines are the lines of the original stream.
+func (m Yaml) patchDocument(doc *yaml.Node, lines []string, i interface{}, replaceComments bool) ([]byte, error) {
+	data, ok := i.(map[string]interface{})
+	if !ok || doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return m.marshalDocument(i, replaceComments)
+	}
+	root := doc.Content[0]
+
+	// yaml.v3 already separates the document head comment from the first key.
+	markBlankLines(root, lines, root.Line)
+
+	p := patcher{replaceComments: replaceComments}
+	if err := p.patchMapping(root, data); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(detectIndent(root))
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	return restoreBlankLines(buf.Bytes()), nil
+}
+
+type patcher struct {
+	replaceComments bool
+}
+
+// patchMapping applies data onto the mapping node in place.
+func (p patcher) patchMapping(node *yaml.Node, data map[string]interface{}) error {
+	seen := make(map[string]bool, len(data))
+	inherited := map[string]interface{}{}
+	content := make([]*yaml.Node, 0, len(node.Content))
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		if k.Value == "<<" && (k.Tag == "!!merge" || k.Tag == "") {
+			// Merge keys are kept as is; the values they bring in are not written again explicitly.
+			// yaml.v3 writes an explicit "!!merge" tag unless it is cleared.
+			k.Tag = ""
+			_ = v.Decode(&inherited)
+			content = append(content, k, v)
+			continue
+		}
+		val, ok := data[k.Value]
+		if !ok || (p.replaceComments && isCommentKey(k.Value)) {
+			continue
+		}
+		seen[k.Value] = true
+		nv, err := p.patchValue(v, val)
+		if err != nil {
+			return err
+		}
+		content = append(content, k, nv)
+	}
+
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if seen[k] || (p.replaceComments && isCommentKey(k)) {
+			continue
+		}
+		if v, ok := inherited[k]; ok && reflect.DeepEqual(v, normalize(data[k])) {
+			continue
+		}
+		vn, err := p.newNode(data[k])
+		if err != nil {
+			return err
+		}
+		content = append(content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, vn)
+	}
+
+	if p.replaceComments {
+		for i := 0; i+1 < len(content); i += 2 {
+			k := content[i]
+			if strings.TrimSpace(strings.ReplaceAll(k.HeadComment, blankMarker, "")) != "" {
+				continue
+			}
+			comments := commentsFor(data, k.Value)
+			if comments == "" {
+				continue
+			}
+			if k.HeadComment != "" && !strings.HasPrefix(comments, blankMarker) {
+				comments = blankMarker + "\n" + comments
+			}
+			k.HeadComment = comments
+		}
+	}
+
+	node.Content = content
+	return nil
+}
+
+// patchSequence applies arr onto the sequence node in place, element by element.
+func (p patcher) patchSequence(node *yaml.Node, arr []interface{}) error {
+	content := make([]*yaml.Node, 0, len(arr))
+	for i, val := range arr {
+		if i < len(node.Content) {
+			nv, err := p.patchValue(node.Content[i], val)
+			if err != nil {
+				return err
+			}
+			content = append(content, nv)
+			continue
+		}
+		nv, err := p.newNode(val)
+		if err != nil {
+			return err
+		}
+		content = append(content, nv)
+	}
+	node.Content = content
+	return nil
+}
+
+// patchValue returns the node for val: the original node when it already holds val, the original node
+// patched in place for maps and arrays, or a new node carrying over the original comments and anchor.
+func (p patcher) patchValue(node *yaml.Node, val interface{}) (*yaml.Node, error) {
+	val = normalize(val)
+
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if node.Kind == yaml.MappingNode {
+			return node, p.patchMapping(node, v)
+		}
+	case []interface{}:
+		if node.Kind == yaml.SequenceNode {
+			return node, p.patchSequence(node, v)
+		}
+	}
+
+	var current interface{}
+	if err := node.Decode(&current); err == nil && reflect.DeepEqual(current, val) {
+		return node, nil
+	}
+
+	n, err := p.newNode(val)
+	if err != nil {
+		return nil, err
+	}
+	n.HeadComment = node.HeadComment
+	n.LineComment = node.LineComment
+	n.FootComment = node.FootComment
+	if node.Kind != yaml.AliasNode {
+		n.Anchor = node.Anchor
+	}
+	if node.Kind == yaml.ScalarNode && n.Kind == yaml.ScalarNode && n.Tag == node.ShortTag() {
+		n.Style = node.Style
+	}
+	return n, nil
+}
+
+// newNode builds a node for val.
+func (p patcher) newNode(val interface{}) (*yaml.Node, error) {
+	switch v := normalize(val).(type) {
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}, nil
+	case map[string]interface{}:
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		return n, p.patchMapping(n, v)
+	case []interface{}:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		return n, p.patchSequence(n, v)
+	default:
+		n := &yaml.Node{}
+		if err := n.Encode(v); err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+}
+
+// normalize converts typed slices and maps (e.g. []map[string]interface{} from merger) to the generic types.
+func normalize(val interface{}) interface{} {
+	switch v := val.(type) {
+	case nil, map[string]interface{}, []interface{}:
+		return val
+	case []map[string]interface{}:
+		out := make([]interface{}, len(v))
+		for i := range v {
+			out[i] = v[i]
+		}
+		return out
+	}
+	rv := reflect.ValueOf(val)
+	if rv.Kind() == reflect.Slice {
+		out := make([]interface{}, rv.Len())
+		for i := range out {
+			out[i] = rv.Index(i).Interface()
+		}
+		return out
+	}
+	return val
+}
+
+// isCommentKey reports whether k is a comment-like key (ends with config.CommentSuffix).
+func isCommentKey(k string) bool {
+	return strings.HasSuffix(k, config.CommentSuffix) && strings.TrimRight(strings.TrimSuffix(k, config.CommentSuffix), "_") != ""
+}
+
+// commentsFor returns the head comment built from the comment keys of key in data; an empty comment is a blank line.
+func commentsFor(data map[string]interface{}, key string) string {
+	var keys []string
+	for k := range data {
+		if isCommentKey(k) && strings.TrimRight(strings.TrimSuffix(k, config.CommentSuffix), "_") == key {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0, len(keys))
+	for _, k := range keys {
+		comment := ""
+		if data[k] != nil {
+			comment = strings.TrimSpace(fmt.Sprint(data[k]))
+		}
+		if comment == "" {
+			lines = append(lines, blankMarker)
+			continue
+		}
+		lines = append(lines, "# "+comment)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// markBlankLines prepends blankMarker to the head comment of every mapping key and sequence item
+// that is preceded by a blank line in the original document. Items of flow collections and nodes on line, the line
+// of the key or item holding node, like the first key of "- name: a", are not marked.
+func markBlankLines(node *yaml.Node, lines []string, line int) {
+	if node.Style&yaml.FlowStyle != 0 {
+		return
+	}
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Line != line {
+				markBlankLine(node.Content[i], lines)
+			}
+			markBlankLines(node.Content[i+1], lines, node.Content[i].Line)
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if item.Line != line {
+				markBlankLine(item, lines)
+			}
+			markBlankLines(item, lines, item.Line)
+		}
+	}
+}
+
+func markBlankLine(node *yaml.Node, lines []string) {
+	first := node.Line
+	if node.HeadComment != "" {
+		first -= strings.Count(node.HeadComment, "\n") + 1
+	}
+	if first < 2 || first-2 >= len(lines) || strings.TrimSpace(lines[first-2]) != "" {
+		return
+	}
+	if node.HeadComment == "" {
+		node.HeadComment = blankMarker
+		return
+	}
+	node.HeadComment = blankMarker + "\n" + node.HeadComment
+}
+
+func restoreBlankLines(b []byte) []byte {
+	lines := strings.Split(string(b), "\n")
+	for i, line := range lines {
+		if strings.TrimSpace(line) == blankMarker {
+			lines[i] = ""
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// detectIndent returns the indentation used by the first nested mapping of root.
+func detectIndent(root *yaml.Node) int {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		k, v := root.Content[i], root.Content[i+1]
+		if v.Kind != yaml.MappingNode || len(v.Content) == 0 || v.Content[0].Line == k.Line {
+			continue
+		}
+		if indent := v.Content[0].Column - k.Column; indent >= 2 && indent <= 9 {
+			return indent
+		}
+	}
+	return defaultIndent
+}
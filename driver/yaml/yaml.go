@@ -12,7 +12,12 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// Yaml implements driver.Driver for YAML. A stream of several "---" separated documents is read as
+// a map with the documents under DocumentsKey, each keyed by the values of Selector or, if it has
+// none of them, by its index in the stream.
 type Yaml struct {
+	// Selector lists the dotted paths identifying a document; DefaultSelector if nil.
+	Selector []string
 }
 
 func init() {
@@ -24,10 +29,35 @@ func New(cfg config.Settings) database.Driver {
 }
 
 func (m Yaml) Unmarshal(bytes []byte, i interface{}) error {
-	return yaml.Unmarshal(bytes, i)
+	out, ok := i.(*map[string]interface{})
+	if !ok {
+		return yaml.Unmarshal(bytes, i)
+	}
+
+	docs, err := decodeDocuments(bytes)
+	if err != nil {
+		return err
+	}
+	if len(docs) < 2 {
+		return yaml.Unmarshal(bytes, i)
+	}
+
+	data, err := m.unmarshalDocuments(docs)
+	if err != nil {
+		return err
+	}
+	*out = data
+	return nil
 }
 
 func (m Yaml) Marshal(i interface{}, replaceComments bool) ([]byte, error) {
+	if data, ok := isMultiDocument(i); ok {
+		return m.marshalDocuments(data, replaceComments)
+	}
+	return m.marshalDocument(i, replaceComments)
+}
+
+func (m Yaml) marshalDocument(i interface{}, replaceComments bool) ([]byte, error) {
 	b, err := yaml.Marshal(i)
 	if err != nil {
 		return nil, err
@@ -1,9 +1,13 @@
 package yaml
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/c2pc/config-migrate/driver"
@@ -517,3 +521,628 @@ func TestDrop(t *testing.T) {
 		t.Errorf("Expected: %s, got: %s", expected, result)
 	}
 }
+
+func TestPatch(t *testing.T) {
+	original := `# Service config
+http:
+  # Bind address, set by ops
+  host: 0.0.0.0 # do not change
+
+  port: "8080"
+defaults: &defaults
+  timeout: 5
+worker:
+  <<: *defaults
+  threads: 2
+obsolete: true
+`
+	data := map[string]interface{}{
+		"http": map[string]interface{}{
+			"host": "0.0.0.0",
+			"port": "8080",
+			"tls":  false,
+		},
+		"defaults": map[string]interface{}{"timeout": 5},
+		"worker":   map[string]interface{}{"timeout": 5, "threads": 4},
+		"log":      map[string]interface{}{"level": "info"},
+	}
+
+	b, err := (Yaml{}).Patch([]byte(original), data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# Service config
+http:
+  # Bind address, set by ops
+  host: 0.0.0.0 # do not change
+
+  port: "8080"
+  tls: false
+defaults: &defaults
+  timeout: 5
+worker:
+  <<: *defaults
+  threads: 4
+log:
+  level: info
+`
+	if string(b) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, b)
+	}
+}
+
+func TestPatch_Comments(t *testing.T) {
+	original := `# kept
+port: 1
+host: a
+`
+	data := map[string]interface{}{
+		"port":                              1,
+		"port" + config.CommentSuffix:       "Generated",
+		"host":                              "a",
+		"host" + config.CommentSuffix:       "",
+		"host" + config.CommentSuffix + "_": "Host comment",
+	}
+
+	b, err := (Yaml{}).Patch([]byte(original), data, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# kept
+port: 1
+
+# Host comment
+host: a
+`
+	if string(b) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, b)
+	}
+}
+
+func TestPatch_FlowCollections(t *testing.T) {
+	original := `x: 1
+
+y: [1, 2]
+
+z: {a: 1, b: 2}
+servers:
+
+    - name: a
+      port: 1
+
+    - name: b
+      port: 2
+`
+	data := map[string]interface{}{
+		"x": 2,
+		"y": []interface{}{1, 2},
+		"z": map[string]interface{}{"a": 1, "b": 2},
+		"servers": []interface{}{
+			map[string]interface{}{"name": "a", "port": 1},
+			map[string]interface{}{"name": "b", "port": 2},
+		},
+	}
+
+	b, err := (Yaml{}).Patch([]byte(original), data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := strings.Replace(original, "x: 1", "x: 2", 1)
+	if string(b) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, b)
+	}
+}
+
+func TestUp_PreserveFormatting(t *testing.T) {
+	defer os.Remove(configPath)
+
+	d := New(config.Settings{
+		Path:                    configPath,
+		Perm:                    0777,
+		UnableToReplaceComments: true,
+		PreserveFormatting:      true,
+	})
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "yaml", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Operator edits the live config between releases
+	edited := "# Operator note\nstr: edited\nnumber: 1\nboolean: true\n"
+	if err := os.WriteFile(configPath, []byte(edited+"version: 1\nforce: false\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := edited + `version: 2
+force: false
+map:
+    map_boolean: false
+    map_number: 2
+    # map_str______ STR
+    map_str: map_str
+`
+	if string(b) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, b)
+	}
+}
+
+func TestUp2_VersionFile(t *testing.T) {
+	versionPath := configPath + ".migrate.json"
+	defer os.Remove(configPath)
+	defer os.Remove(versionPath)
+
+	d := New(config.Settings{
+		Path:        configPath,
+		Perm:        0777,
+		VersionFile: versionPath,
+	})
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "yaml", d)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(2); err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := readConfigFileAndConvert(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected, err := convertMapToJsonString(map[string]interface{}{
+		"str":     "str",
+		"number":  1,
+		"boolean": true,
+		"map": map[string]interface{}{
+			"map_str______": "map_str______ STR",
+			"map_str":       "map_str",
+			"map_number":    2,
+			"map_boolean":   false,
+		},
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if result != expected {
+		t.Errorf("Expected: %s, got: %s", expected, result)
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 2 {
+		t.Errorf("Expected: %d, got: %d", 2, v)
+	}
+
+	if f != false {
+		t.Errorf("Expected: %t, got: %t", false, f)
+	}
+}
+
+func TestUp2_SchemaRollback(t *testing.T) {
+	defer os.Remove(configPath)
+
+	d := New(config.Settings{
+		Path:   configPath,
+		Perm:   0777,
+		Schema: []byte(`{"type": "object", "required": ["str", "host"]}`),
+	})
+
+	m, err := migrate.NewWithDatabaseInstance(getSourceURL(), "yaml", d)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	before, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Migration 2 drops host
+	err = m.Steps(1)
+	var schemaErr *config.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Errorf("Expected SchemaError, got: %v", err)
+		return
+	}
+
+	if schemaErr.Version != 2 || len(schemaErr.Errors) != 1 || !strings.HasPrefix(schemaErr.Errors[0], "(root): missing properties: 'host'") {
+		t.Errorf("Unexpected schema error: %v", schemaErr)
+	}
+
+	after, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if string(after) != string(before) {
+		t.Errorf("Expected config to be restored:\n%s\ngot:\n%s", before, after)
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 1 {
+		t.Errorf("Expected version %d, got: %d", 1, v)
+	}
+
+	if f != false {
+		t.Errorf("Expected force %t, got: %t", false, f)
+	}
+}
+
+const documentsPath = "./examples/documents.yaml"
+const documentsMigrationsPath = "./examples/documents/migrations"
+
+func getDocumentsConfig() database.Driver {
+	return New(config.Settings{
+		Path:                    documentsPath,
+		Perm:                    0777,
+		UnableToReplaceComments: true,
+	})
+}
+
+func TestUp1_Documents(t *testing.T) {
+	defer os.Remove(documentsPath)
+
+	m, err := migrate.NewWithDatabaseInstance("file://"+documentsMigrationsPath, "yaml", getDocumentsConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err := os.ReadFile(documentsPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := `apiVersion: v1
+data:
+
+    # Overridden per environment
+    log_level: info
+force: false
+kind: ConfigMap
+metadata:
+    name: app
+version: 1
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+    labels:
+        version: "1.0"
+    name: web
+spec:
+    replicas: 1
+---
+quota: 10
+tenant: acme
+`
+
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 1 {
+		t.Errorf("Expected version %d, got: %d", 1, v)
+	}
+
+	if f != false {
+		t.Errorf("Expected force %t, got: %t", false, f)
+	}
+}
+
+func TestUp2_Documents(t *testing.T) {
+	defer os.Remove(documentsPath)
+
+	m, err := migrate.NewWithDatabaseInstance("file://"+documentsMigrationsPath, "yaml", getDocumentsConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Operators scale the deployment and raise the quota between releases
+	data, err := os.ReadFile(documentsPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	data = []byte(strings.NewReplacer("replicas: 1", "replicas: 3", "quota: 10", "quota: 20").Replace(string(data)))
+	if err := os.WriteFile(documentsPath, data, 0777); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Error(err)
+		return
+	}
+
+	data, err = os.ReadFile(documentsPath)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	result := map[string]interface{}{}
+	if err := (Yaml{}).Unmarshal(data, &result); err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := map[string]interface{}{
+		"version": 2, "force": false,
+		DocumentsKey: map[string]interface{}{
+			"ConfigMap/app": map[string]interface{}{
+				"apiVersion": "v1", "kind": "ConfigMap",
+				"metadata": map[string]interface{}{"name": "app"},
+				"data":     map[string]interface{}{"log_level": "info", "feature_flags": "beta"},
+			},
+			"Deployment/web": map[string]interface{}{
+				"apiVersion": "apps/v1", "kind": "Deployment",
+				"metadata": map[string]interface{}{"name": "web", "labels": map[string]interface{}{"version": "1.1"}},
+				"spec":     map[string]interface{}{"replicas": 3},
+			},
+			"2": map[string]interface{}{
+				"tenant": "acme",
+				"limits": map[string]interface{}{"quota": 20},
+			},
+			"Service/web": map[string]interface{}{
+				"apiVersion": "v1", "kind": "Service",
+				"metadata": map[string]interface{}{"name": "web"},
+				"spec":     map[string]interface{}{"port": 80},
+			},
+		},
+		orderKey: []interface{}{"ConfigMap/app", "Deployment/web", "2", "Service/web"},
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected: %v, got: %v\n%s", expected, result, data)
+	}
+}
+
+func TestUp_SingleToDocuments(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0777); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"1_config.up.yaml":   "kind: ConfigMap\nmetadata:\n  name: app\ndata:\n  level: info\n",
+		"1_config.down.yaml": "",
+		"2_config.up.yaml": "kind: ConfigMap\nmetadata:\n  name: app\ndata:\n  level: info\n" +
+			"---\nkind: Service\nmetadata:\n  name: web\nspec:\n  port: 80\n",
+		"2_config.down.yaml": "",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(migrations, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := migrate.NewWithDatabaseInstance("file://"+filepath.ToSlash(migrations), "yaml", New(config.Settings{Path: path}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Steps(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// The operator changes the single document before the migration adds a second one
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Replace(string(data), "level: info", "level: debug", 1)), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `data:
+    level: debug
+force: false
+kind: ConfigMap
+metadata:
+    name: app
+version: 2
+---
+kind: Service
+metadata:
+    name: web
+spec:
+    port: 80
+`
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
+
+func TestUp_DeprecatedMapNonStringKeys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0777); err != nil {
+		t.Fatal(err)
+	}
+	up := `compression: gzip
+compression_deprecated_cast: string
+compression_deprecated_map:
+  true: gzip
+  false: none
+log:
+  level: info
+  level_deprecated_cast: string
+  level_deprecated_map:
+    0: error
+    1: warn
+    2: info
+`
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.up.yaml"), []byte(up), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(migrations, "1_config.down.yaml"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("compression: false\nlog:\n  level: 1\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(config.Settings{Path: path, VersionFile: path + ".migrate.json", StrictMerge: true})
+	m, err := migrate.NewWithDatabaseInstance("file://"+filepath.ToSlash(migrations), "yaml", d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `compression: none
+log:
+    level: warn
+`
+	if string(data) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
+
+func TestUp3_Documents_Invalid_Migration_File(t *testing.T) {
+	defer os.Remove(documentsPath)
+
+	m, err := migrate.NewWithDatabaseInstance("file://"+documentsMigrationsPath, "yaml", getDocumentsConfig())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Two documents with the same selector cannot be told apart
+	if err := m.Steps(3); err == nil {
+		t.Error("expected error")
+		return
+	}
+
+	v, f, err := m.Version()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if v != 3 {
+		t.Errorf("Expected version %d, got: %d", 3, v)
+	}
+
+	if f != true {
+		t.Errorf("Expected force %t, got: %t", true, f)
+	}
+}
+
+func TestPatch_Documents(t *testing.T) {
+	original := `# Tenant A
+tenant: a
+quota:  5
+---
+
+# Tenant B
+tenant: b
+quota: 7
+`
+	y := Yaml{Selector: []string{"tenant"}}
+
+	data := map[string]interface{}{}
+	if err := y.Unmarshal([]byte(original), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	docs := data[DocumentsKey].(map[string]interface{})
+	docs["b"].(map[string]interface{})["quota"] = 9
+	docs["c"] = map[string]interface{}{"tenant": "c", "quota": 1}
+
+	b, err := y.Patch([]byte(original), data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# Tenant A
+tenant: a
+quota: 5
+---
+# Tenant B
+tenant: b
+quota: 9
+---
+quota: 1
+tenant: c
+`
+	if string(b) != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, b)
+	}
+}
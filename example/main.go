@@ -9,7 +9,7 @@ const configPath = "config.yaml"
 
 func main() {
 	//Run config migrations
-	if err := runMigrationWithComments(configPath); err != nil {
+	if err := runMigrationWithMigrator(configPath); err != nil {
 		log.Fatalf("Error running migration: %s", err)
 	}
 
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"errors"
 
-	migrator "github.com/c2pc/config-migrate/driver"
+	driver "github.com/c2pc/config-migrate/driver"
 	"github.com/c2pc/config-migrate/driver/yaml"
+	"github.com/c2pc/config-migrate/migrator"
 	_ "github.com/c2pc/config-migrate/replacer/ip"
 	_ "github.com/c2pc/config-migrate/replacer/project_name"
 	_ "github.com/c2pc/config-migrate/replacer/random"
@@ -41,7 +43,7 @@ func runMigration(path string) error {
 
 func runMigrationWithComments(path string) error {
 	//Enable to replace comments
-	yamlMigr := yaml.New(migrator.Settings{
+	yamlMigr := yaml.New(driver.Settings{
 		Path:                    path,
 		Perm:                    0777,
 		UnableToReplaceComments: true,
@@ -68,3 +70,18 @@ func runMigrationWithComments(path string) error {
 
 	return nil
 }
+
+func runMigrationWithMigrator(path string) error {
+	//Create migrator from embedded migrations
+	m, err := migrator.New(fs, "migrations", &yaml.Yaml{}, driver.Settings{
+		Path:                    path,
+		Perm:                    0777,
+		UnableToReplaceComments: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	//Run migrations, no change is not an error
+	return m.Up(context.Background())
+}
@@ -0,0 +1,5 @@
+package cli
+
+import (
+	_ "github.com/c2pc/config-migrate/driver/dotenv"
+)
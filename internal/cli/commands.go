@@ -3,13 +3,18 @@ package cli
 import (
 	"errors"
 	"fmt"
+	"io"
+	nurl "net/url"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
+	config "github.com/c2pc/config-migrate/driver"
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	_ "github.com/golang-migrate/migrate/v4/source/stub"
 )
@@ -176,6 +181,189 @@ func upCmd(m *migrate.Migrate, limit int) error {
 	return nil
 }
 
+// planCmd applies all or limit up migrations to fileURL in dry-run mode and writes the resulting file to w.
+// With -verbose the file after each migration is printed to the log as well.
+func planCmd(w io.Writer, sourceURL, fileURL string, limit int) error {
+	m, c, err := newDryRunMigrate(sourceURL, fileURL)
+	if err != nil {
+		return err
+	}
+
+	if err := upCmd(m, limit); err != nil {
+		return err
+	}
+
+	steps, data, err := c.Plan()
+	if err != nil {
+		return err
+	}
+
+	if log.verbose {
+		for _, step := range steps {
+			log.Printf("after migration %d:\n%s\n", step.Version, step.Data)
+		}
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// diffCmd applies all or limit up migrations to fileURL in dry-run mode and writes the unified diff of each one to w.
+// With -verbose the changed key paths are printed to the log as well.
+func diffCmd(w io.Writer, sourceURL, fileURL string, limit int) error {
+	m, c, err := newDryRunMigrate(sourceURL, fileURL)
+	if err != nil {
+		return err
+	}
+
+	var diffs []config.Diff
+	c.SetDiffHandler(func(d config.Diff) {
+		diffs = append(diffs, d)
+	})
+	defer c.SetDiffHandler(nil)
+
+	if err := upCmd(m, limit); err != nil {
+		return err
+	}
+
+	for _, d := range diffs {
+		if log.verbose {
+			for _, change := range d.Changes {
+				log.Printf("migration %d: %s %s: %v -> %v\n", d.Version, change.Kind, change.Path, change.Old, change.New)
+			}
+		}
+
+		if d.Unified == "" {
+			fmt.Fprintf(w, "# migration %d: no changes\n", d.Version)
+			continue
+		}
+		fmt.Fprintf(w, "# migration %d\n%s", d.Version, d.Unified)
+	}
+	return nil
+}
+
+// explainCmd applies all or limit up migrations to fileURL in dry-run mode and writes to w where every value of the
+// config came from after each one.
+func explainCmd(w io.Writer, sourceURL, fileURL string, limit int) error {
+	m, c, err := newDryRunMigrate(sourceURL, fileURL)
+	if err != nil {
+		return err
+	}
+
+	var explanations []config.Explanation
+	c.SetExplainHandler(func(e config.Explanation) {
+		explanations = append(explanations, e)
+	})
+	defer c.SetExplainHandler(nil)
+
+	if err := upCmd(m, limit); err != nil {
+		return err
+	}
+
+	for _, e := range explanations {
+		fmt.Fprintf(w, "# migration %d\n", e.Version)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "PATH\tSOURCE\tDIRECTIVE\tFROM\tVALUE")
+		for _, entry := range e.Trace {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%v\n", entry.Path, entry.Source, entry.Directive, entry.From, entry.Value)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newDryRunMigrate opens fileURL in dry-run mode and returns a migrate instance running sourceURL against it.
+func newDryRunMigrate(sourceURL, fileURL string) (*migrate.Migrate, *config.Config, error) {
+	dryRunURL, err := withDryRun(fileURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	d, err := database.Open(dryRunURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, ok := d.(*config.Config)
+	if !ok {
+		return nil, nil, fmt.Errorf("file driver of %s does not support dry run", fileURL)
+	}
+
+	m, err := migrate.NewWithDatabaseInstance(sourceURL, "config", d)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.Log = log
+
+	return m, c, nil
+}
+
+// historyCmd writes the migration history of fileURL to w, oldest first.
+func historyCmd(w io.Writer, fileURL string) error {
+	d, err := database.Open(fileURL)
+	if err != nil {
+		return err
+	}
+
+	c, ok := d.(*config.Config)
+	if !ok {
+		return fmt.Errorf("file driver of %s does not keep a history", fileURL)
+	}
+
+	entries, err := c.History()
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		log.Println("no history, set x-history-file in the file URL to record it")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tDIRECTION\tVERSION\tHOSTNAME\tMIGRATION SHA256\tFILE SHA256")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Direction, e.Version, e.Hostname, e.MigrationChecksum, e.FileChecksum)
+	}
+	return tw.Flush()
+}
+
+// restoreCmd restores the config file of fileURL from the backup selected by target: a version, a backup file or
+// empty for the newest backup.
+func restoreCmd(fileURL, target string) error {
+	d, err := database.Open(fileURL)
+	if err != nil {
+		return err
+	}
+
+	c, ok := d.(*config.Config)
+	if !ok {
+		return fmt.Errorf("file driver of %s does not support backups", fileURL)
+	}
+
+	b, err := c.Restore(target)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Restored %s (version %d)\n", b.Path, b.Version)
+	return nil
+}
+
+// withDryRun adds x-dry-run=true to the query of a file driver URL.
+func withDryRun(fileURL string) (string, error) {
+	u, err := nurl.Parse(fileURL)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	q.Set("x-dry-run", "true")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
 func downCmd(m *migrate.Migrate, limit int) error {
 	if limit >= 0 {
 		if err := m.Steps(-limit); err != nil {
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/golang-migrate/migrate/v4"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -290,3 +291,215 @@ func TestNumDownFromArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestWithDryRun(t *testing.T) {
+	cases := []struct {
+		name     string
+		url      string
+		expected string
+	}{
+		{"no query", "json://./config.json", "json://./config.json?x-dry-run=true"},
+		{"with query", "json://./config.json?x-version-file=v.json", "json://./config.json?x-dry-run=true&x-version-file=v.json"},
+		{"dry run off", "json://./config.json?x-dry-run=false", "json://./config.json?x-dry-run=true"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := withDryRun(c.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.expected {
+				t.Errorf("expected %q, got %q", c.expected, got)
+			}
+		})
+	}
+}
+
+// writeMigrations creates a migrations directory and a config file for the dry-run commands.
+func writeMigrations(t *testing.T) (string, string) {
+	t.Helper()
+	dir := t.TempDir()
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"1_config.up.json":   `{"port": 8080}`,
+		"1_config.down.json": `{}`,
+		"2_config.up.json":   `{"port": 8080, "host": "localhost"}`,
+		"2_config.down.json": `{"port": 8080}`,
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(migrations, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(`{"port": 80}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return migrations, path
+}
+
+func TestUpCmd_PreserveFormatting(t *testing.T) {
+	dir := t.TempDir()
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"1_config.up.yaml":   "port: 8080\nhost: localhost\n",
+		"1_config.down.yaml": "",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(migrations, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("# Set by ops\nport: 80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := migrate.New("file://"+migrations, "yaml://"+path+"?x-preserve-formatting=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upCmd(m, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "# Set by ops\nport: 80\n") || !strings.Contains(string(data), "host: localhost") {
+		t.Errorf("expected the hand-written comment to be kept, got %s", data)
+	}
+}
+
+func TestPlanCmd(t *testing.T) {
+	migrations, path := writeMigrations(t)
+
+	var out strings.Builder
+	if err := planCmd(&out, "file://"+migrations, "json://"+path, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{`"port": 80`, `"host": "localhost"`, `"version": 2`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %s in plan, got %s", want, out.String())
+		}
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != `{"port": 80}` {
+		t.Errorf("config changed by plan: %s", after)
+	}
+}
+
+func TestDiffCmd(t *testing.T) {
+	migrations, path := writeMigrations(t)
+
+	var out strings.Builder
+	if err := diffCmd(&out, "file://"+migrations, "json://"+path, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"# migration 1: no changes", "# migration 2\n--- " + path, `+    "host": "localhost",`} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in diff, got %s", want, out.String())
+		}
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != `{"port": 80}` {
+		t.Errorf("config changed by diff: %s", after)
+	}
+}
+
+func TestExplainCmd(t *testing.T) {
+	migrations, path := writeMigrations(t)
+
+	var out strings.Builder
+	if err := explainCmd(&out, "file://"+migrations, "json://"+path, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"# migration 1\nPATH", "# migration 2\n", "host  new", "port  old"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in explanation, got %s", want, out.String())
+		}
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(after) != `{"port": 80}` {
+		t.Errorf("config changed by explain: %s", after)
+	}
+}
+
+func TestHistoryCmd(t *testing.T) {
+	migrations, path := writeMigrations(t)
+	fileURL := "json://" + path + "?x-history-file=" + path + ".history.jsonl"
+
+	m, err := migrate.New("file://"+migrations, fileURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upCmd(m, -1); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := historyCmd(&out, fileURL); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "TIME") {
+		t.Fatalf("expected a header and 2 entries, got %s", out.String())
+	}
+	for i, line := range lines[1:] {
+		if fields := strings.Fields(line); fields[1] != "up" || fields[2] != strconv.Itoa(i+1) {
+			t.Errorf("unexpected entry %q", line)
+		}
+	}
+}
+
+func TestRestoreCmd(t *testing.T) {
+	migrations, path := writeMigrations(t)
+	fileURL := "json://" + path + "?x-backup=true&x-backup-keep=1"
+
+	m, err := migrate.New("file://"+migrations, fileURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := upCmd(m, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := upCmd(m, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := restoreCmd(fileURL, "1"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"version": 1`) || strings.Contains(string(data), "host") {
+		t.Errorf("unexpected restored config %s", data)
+	}
+
+	if err := restoreCmd(fileURL, "0"); err == nil {
+		t.Error("expected error for a backup removed by x-backup-keep")
+	}
+}
@@ -25,12 +25,19 @@ const (
            Use -tz option to specify the timezone that will be used when generating non-sequential migrations (defaults: UTC).
 `
 	gotoUsage = `goto V       Migrate to version V`
-	upUsage   = `up [N]       Apply all or N up migrations`
-	downUsage = `down [N] [-all]    Apply all or N down migrations
+	upUsage   = `up [-dry-run] [N]    Apply all or N up migrations
+	Use -dry-run to print the resulting file instead of writing it`
+	planUsage    = `plan [N]     Print the file that all or N up migrations would produce, without writing it`
+	diffUsage    = `diff [N]     Print a unified diff of the changes each of all or N up migrations would make, without writing them`
+	explainUsage = `explain [N]  Print where every value of the file would come from after each of all or N up migrations, without writing it`
+	downUsage    = `down [N] [-all]    Apply all or N down migrations
 	Use -all to apply all down migrations`
 	dropUsage = `drop [-f]    Drop everything inside file
 	Use -f to bypass confirmation`
-	forceUsage = `force V      Set version V but don't run migration (ignores dirty state)`
+	forceUsage   = `force V      Set version V but don't run migration (ignores dirty state)`
+	historyUsage = `history      Print the applied migrations recorded in the history file (x-history-file)`
+	restoreUsage = `restore [V|FILE]  Restore the newest backup, the newest backup of version V or the backup FILE
+	Backups are made with x-backup=true and read from x-backup-dir`
 )
 
 func handleSubCmdHelp(help bool, usage string, flagSet *flag.FlagSet) {
@@ -83,6 +90,11 @@ Options:
   -help            Print usage
 
 Commands:
+  %s
+  %s
+  %s
+  %s
+  %s
   %s
   %s
   %s
@@ -92,7 +104,7 @@ Commands:
   version      Print current migration version
 
 Source drivers: `+strings.Join(source.List(), ", ")+`
-File drivers: `+strings.Join(database.List(), ", ")+"\n", createUsage, gotoUsage, upUsage, downUsage, dropUsage, forceUsage)
+File drivers: `+strings.Join(database.List(), ", ")+"\n", createUsage, gotoUsage, upUsage, planUsage, diffUsage, explainUsage, downUsage, dropUsage, forceUsage, historyUsage, restoreUsage)
 	}
 
 	flag.Parse()
@@ -215,14 +227,20 @@ File drivers: `+strings.Join(database.List(), ", ")+"\n", createUsage, gotoUsage
 			log.Println("Finished after", time.Since(startTime))
 		}
 
-	case "up":
-		upSet, helpPtr := newFlagSetWithHelp("up")
+	case "up", "plan":
+		upSet, helpPtr := newFlagSetWithHelp(flag.Arg(0))
+		usage := planUsage
+		dryRun := true
+		if flag.Arg(0) == "up" {
+			usage = upUsage
+			upSet.BoolVar(&dryRun, "dry-run", false, "Print the resulting file instead of writing it")
+		}
 
 		if err := upSet.Parse(args); err != nil {
 			log.fatalErr(err)
 		}
 
-		handleSubCmdHelp(*helpPtr, upUsage, upSet)
+		handleSubCmdHelp(*helpPtr, usage, upSet)
 
 		if migraterErr != nil {
 			log.fatalErr(migraterErr)
@@ -237,7 +255,49 @@ File drivers: `+strings.Join(database.List(), ", ")+"\n", createUsage, gotoUsage
 			limit = int(n)
 		}
 
-		if err := upCmd(migrater, limit); err != nil {
+		if dryRun {
+			if err := planCmd(os.Stdout, *sourcePtr, *filePtr, limit); err != nil {
+				log.fatalErr(err)
+			}
+		} else if err := upCmd(migrater, limit); err != nil {
+			log.fatalErr(err)
+		}
+
+		if log.verbose {
+			log.Println("Finished after", time.Since(startTime))
+		}
+
+	case "diff", "explain":
+		diffSet, helpPtr := newFlagSetWithHelp(flag.Arg(0))
+		usage := diffUsage
+		if flag.Arg(0) == "explain" {
+			usage = explainUsage
+		}
+
+		if err := diffSet.Parse(args); err != nil {
+			log.fatalErr(err)
+		}
+
+		handleSubCmdHelp(*helpPtr, usage, diffSet)
+
+		if migraterErr != nil {
+			log.fatalErr(migraterErr)
+		}
+
+		limit := -1
+		if diffSet.NArg() > 0 {
+			n, err := strconv.ParseUint(diffSet.Arg(0), 10, 64)
+			if err != nil {
+				log.fatal("error: can't read limit argument N")
+			}
+			limit = int(n)
+		}
+
+		cmd := diffCmd
+		if flag.Arg(0) == "explain" {
+			cmd = explainCmd
+		}
+		if err := cmd(os.Stdout, *sourcePtr, *filePtr, limit); err != nil {
 			log.fatalErr(err)
 		}
 
@@ -354,6 +414,40 @@ File drivers: `+strings.Join(database.List(), ", ")+"\n", createUsage, gotoUsage
 			log.Println("Finished after", time.Since(startTime))
 		}
 
+	case "history":
+		historySet, helpPtr := newFlagSetWithHelp("history")
+
+		if err := historySet.Parse(args); err != nil {
+			log.fatalErr(err)
+		}
+
+		handleSubCmdHelp(*helpPtr, historyUsage, historySet)
+
+		if err := historyCmd(os.Stdout, *filePtr); err != nil {
+			log.fatalErr(err)
+		}
+
+	case "restore":
+		restoreSet, helpPtr := newFlagSetWithHelp("restore")
+
+		if err := restoreSet.Parse(args); err != nil {
+			log.fatalErr(err)
+		}
+
+		handleSubCmdHelp(*helpPtr, restoreUsage, restoreSet)
+
+		if restoreSet.NArg() > 1 {
+			log.fatal("error: too many arguments")
+		}
+
+		if err := restoreCmd(*filePtr, restoreSet.Arg(0)); err != nil {
+			log.fatalErr(err)
+		}
+
+		if log.verbose {
+			log.Println("Finished after", time.Since(startTime))
+		}
+
 	case "version":
 		if migraterErr != nil {
 			log.fatalErr(migraterErr)
@@ -31,3 +31,12 @@ func ParseURL(url string) (string, error) {
 	}
 	return p, nil
 }
+
+// ParseQuery returns the query parameters of url.
+func ParseQuery(url string) (nurl.Values, error) {
+	u, err := nurl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	return u.Query(), nil
+}
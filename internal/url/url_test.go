@@ -63,3 +63,22 @@ func TestParseURL(t *testing.T) {
 		})
 	}
 }
+
+func TestParseQuery(t *testing.T) {
+	q, err := ParseQuery("yaml://./config.yaml?x-version-file=./config.yaml.migrate.json&x-version-key=_meta.schema_version")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := q.Get("x-version-file"); got != "./config.yaml.migrate.json" {
+		t.Errorf("Expected ./config.yaml.migrate.json, got %s", got)
+	}
+
+	if got := q.Get("x-version-key"); got != "_meta.schema_version" {
+		t.Errorf("Expected _meta.schema_version, got %s", got)
+	}
+
+	if _, err := ParseQuery("1http://foo.com"); err == nil {
+		t.Error("expected error for invalid URL")
+	}
+}
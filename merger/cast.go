@@ -0,0 +1,227 @@
+package merger
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys ending with _deprecated_cast convert the value of the target key of the old config to the type of the new
+// default, or to the type named by the value: string, int, float, bool, duration or array. Without it an old value of
+// another type is replaced by the default.
+const deprecatedCastSuffix = "_deprecated_cast"
+
+// Types of _deprecated_cast.
+const (
+	castString   = "string"
+	castInt      = "int"
+	castFloat    = "float"
+	castBool     = "bool"
+	castDuration = "duration"
+	castArray    = "array"
+)
+
+// applyDeprecatedCastInto applies key_deprecated_cast: the value in m[key], if a directive put one of another type
+// there, or else the value at the same path of old is converted and written to m[key]. Values that already have the
+// type and nulls are left alone.
+func applyDeprecatedCastInto(m, new, old map[string]interface{}, r *reporter, t *tracer, prefix string) {
+	if m == nil || new == nil || old == nil {
+		return
+	}
+	for k, v := range new {
+		if !strings.HasSuffix(k, deprecatedCastSuffix) {
+			if newMap, ok := v.(map[string]interface{}); ok && !isDirectiveKey(k) {
+				if mChild, ok := m[k].(map[string]interface{}); ok {
+					applyDeprecatedCastInto(mChild, newMap, old, r, t, joinKey(prefix, k))
+				}
+			}
+			continue
+		}
+		delete(m, k)
+
+		spec, ok := v.(string)
+		if !ok && v != nil {
+			r.add(prefix, k, v, "value must be empty or a type")
+			continue
+		}
+		targetKey := strings.TrimSuffix(k, deprecatedCastSuffix)
+		kind := strings.ToLower(strings.TrimSpace(spec))
+		if kind == "" {
+			kind = castKindOf(new[targetKey])
+		}
+		switch kind {
+		case castString, castInt, castFloat, castBool, castDuration, castArray:
+		default:
+			r.add(prefix, k, v, "unknown type")
+			continue
+		}
+
+		var value interface{}
+		cur := m[targetKey]
+		oldVal, found := getValueByPath(old, joinKey(prefix, targetKey))
+		switch {
+		case cur != nil && !hasCastKind(cur, kind):
+			value = cur
+		case oldVal != nil && !hasCastKind(oldVal, kind):
+			value = oldVal
+		default:
+			// A value moved in by another directive needs no old path.
+			if !found && reflect.DeepEqual(cur, new[targetKey]) {
+				r.missing(prefix, k, joinKey(prefix, targetKey))
+			}
+			continue
+		}
+
+		converted, err := castValue(value, kind, new[targetKey])
+		if err != nil {
+			r.add(prefix, k, fmt.Sprint(value), err.Error())
+			continue
+		}
+		m[targetKey] = converted
+		t.record(joinKey(prefix, targetKey), SourceCast, prefix, k, kind)
+	}
+}
+
+// castKindOf returns the _deprecated_cast type of v; "" if it has none.
+func castKindOf(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return castString
+	case int, int64:
+		return castInt
+	case float64:
+		return castFloat
+	case bool:
+		return castBool
+	case []interface{}:
+		return castArray
+	}
+	return ""
+}
+
+// hasCastKind reports whether v already has the type kind.
+func hasCastKind(v interface{}, kind string) bool {
+	if kind == castDuration {
+		s, ok := v.(string)
+		if !ok {
+			return false
+		}
+		_, err := time.ParseDuration(s)
+		return err == nil
+	}
+	return castKindOf(v) == kind
+}
+
+// castValue converts v to kind. template is the new default; for arrays, its first element gives the element type.
+// An array of one element converts to a scalar and a scalar to an array of one element.
+func castValue(v interface{}, kind string, template interface{}) (interface{}, error) {
+	if arr, ok := v.([]interface{}); ok && kind != castArray {
+		if len(arr) != 1 {
+			return nil, fmt.Errorf("cannot convert an array of %d elements to %s", len(arr), kind)
+		}
+		v = arr[0]
+	}
+
+	switch kind {
+	case castArray:
+		if arr, ok := v.([]interface{}); ok {
+			return arr, nil
+		}
+		if tmpl, ok := template.([]interface{}); ok && len(tmpl) > 0 {
+			if elemKind := castKindOf(tmpl[0]); elemKind != "" && !hasCastKind(v, elemKind) {
+				elem, err := castValue(v, elemKind, tmpl[0])
+				if err != nil {
+					return nil, err
+				}
+				v = elem
+			}
+		}
+		return []interface{}{v}, nil
+
+	case castString:
+		switch t := v.(type) {
+		case string:
+			return t, nil
+		case float64:
+			return strconv.FormatFloat(t, 'f', -1, 64), nil
+		case int, int64, bool:
+			return fmt.Sprint(t), nil
+		}
+
+	case castInt:
+		switch t := v.(type) {
+		case int:
+			return t, nil
+		case int64:
+			return int(t), nil
+		case float64:
+			if t == float64(int(t)) {
+				return int(t), nil
+			}
+		case string:
+			if n, err := strconv.Atoi(strings.TrimSpace(t)); err == nil {
+				return n, nil
+			}
+			if f, err := strconv.ParseFloat(strings.TrimSpace(t), 64); err == nil && f == float64(int(f)) {
+				return int(f), nil
+			}
+		}
+
+	case castFloat:
+		switch t := v.(type) {
+		case float64:
+			return t, nil
+		case int:
+			return float64(t), nil
+		case int64:
+			return float64(t), nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(t), 64); err == nil {
+				return f, nil
+			}
+		}
+
+	case castBool:
+		switch t := v.(type) {
+		case bool:
+			return t, nil
+		case int:
+			if t == 0 || t == 1 {
+				return t == 1, nil
+			}
+		case float64:
+			if t == 0 || t == 1 {
+				return t == 1, nil
+			}
+		case string:
+			switch strings.ToLower(strings.TrimSpace(t)) {
+			case "1", "t", "true", "yes", "y", "on":
+				return true, nil
+			case "0", "f", "false", "no", "n", "off":
+				return false, nil
+			}
+		}
+
+	case castDuration:
+		// Numbers are seconds
+		switch t := v.(type) {
+		case int:
+			return (time.Duration(t) * time.Second).String(), nil
+		case int64:
+			return (time.Duration(t) * time.Second).String(), nil
+		case float64:
+			return time.Duration(t * float64(time.Second)).String(), nil
+		case string:
+			s := strings.TrimSpace(t)
+			if _, err := time.ParseDuration(s); err == nil {
+				return s, nil
+			}
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return time.Duration(f * float64(time.Second)).String(), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("cannot convert to %s", kind)
+}
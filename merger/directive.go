@@ -0,0 +1,223 @@
+package merger
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Phase orders the directives applied after the new and the old config are merged; lower phases run first.
+// Built-in directives run before custom directives of the same phase.
+type Phase int
+
+const (
+	// PhaseDeprecated — _deprecated moves values of the old config.
+	PhaseDeprecated Phase = 100
+	// PhaseCast — _deprecated_cast converts old values to the type of the new default.
+	PhaseCast Phase = 150
+	// PhaseMap — _deprecated_map renames old values.
+	PhaseMap Phase = 170
+	// PhaseExpand — _deprecated_expand builds arrays of objects from arrays of the old config.
+	PhaseExpand Phase = 200
+	// PhaseCollapse — _deprecated_collapse builds arrays of scalars from arrays of objects of the old config.
+	PhaseCollapse Phase = 300
+	// PhaseConcat — _deprecated_concat joins values of the merged config.
+	PhaseConcat Phase = 400
+	// PhaseReplace — _deprecated_replace forces values of the new config.
+	PhaseReplace Phase = 500
+	// PhaseRemove — _deprecated_remove removes keys.
+	PhaseRemove Phase = 600
+)
+
+// DirectiveContext is passed to a DirectiveFunc for every key of the new config that ends with its suffix.
+type DirectiveContext struct {
+	// Old — root of the old config. It must not be changed.
+	Old map[string]interface{}
+
+	// New — root of the new config as passed to Merge. It must not be changed.
+	New map[string]interface{}
+
+	// Merged — root of the merged config after the earlier phases; change it in place.
+	Merged map[string]interface{}
+
+	// Parent — the map of the merged config at Path, which holds the target key.
+	Parent map[string]interface{}
+
+	// Path — dot-separated path of the map holding the directive key; "" at the root.
+	Path string
+
+	// Key — the directive key, e.g. "port_from_env".
+	Key string
+
+	// Target — the directive key without the suffix, e.g. "port".
+	Target string
+
+	// Value — the value of the directive key in the new config.
+	Value interface{}
+}
+
+// TargetPath returns the dot-separated path of the target key, e.g. "http.port".
+func (c *DirectiveContext) TargetPath() string {
+	return joinKey(c.Path, c.Target)
+}
+
+// OldValue returns the value at the dot-separated path of the old config.
+func (c *DirectiveContext) OldValue(path string) (interface{}, bool) {
+	return getValueByPath(c.Old, path)
+}
+
+// Set sets the target key of the merged config to value.
+func (c *DirectiveContext) Set(value interface{}) {
+	c.Parent[c.Target] = value
+}
+
+// DirectiveFunc applies a custom directive. An error means the directive could not be applied; it is reported
+// in DirectiveErrors with Options.Strict and ignored otherwise.
+type DirectiveFunc func(ctx *DirectiveContext) error
+
+type directive struct {
+	suffix string
+	phase  Phase
+	fn     DirectiveFunc
+}
+
+// builtinSuffixes are the suffixes of the directive keys handled by Merge itself.
+var builtinSuffixes = []string{
+	deprecatedSuffix,
+	deprecatedCastSuffix,
+	deprecatedMapSuffix,
+	deprecatedExpandSuffix,
+	deprecatedCollapseSuffix,
+	deprecatedConcatSuffix,
+	deprecatedReplaceSuffix,
+	deprecatedRemoveSuffix,
+	mergeKeySuffix,
+	mergeModeSuffix,
+}
+
+var directivesMu sync.RWMutex
+var directives []directive
+
+// RegisterDirective globally registers a custom directive: for every key of the new config ending with suffix,
+// fn is called in phase after the configs are merged, and the key is removed from the result.
+// It panics if fn is nil or suffix overlaps the suffix of another directive.
+func RegisterDirective(suffix string, phase Phase, fn DirectiveFunc) {
+	directivesMu.Lock()
+	defer directivesMu.Unlock()
+	if fn == nil {
+		panic("Register directive is nil")
+	}
+	if suffix == "" {
+		panic("Register directive with empty suffix")
+	}
+	for _, s := range builtinSuffixes {
+		if strings.HasSuffix(s, suffix) || strings.HasSuffix(suffix, s) {
+			panic("Register directive " + suffix + " overlaps built-in directive " + s)
+		}
+	}
+	for _, d := range directives {
+		if strings.HasSuffix(d.suffix, suffix) || strings.HasSuffix(suffix, d.suffix) {
+			panic("Register called twice for directive " + suffix)
+		}
+	}
+	directives = append(directives, directive{suffix: suffix, phase: phase, fn: fn})
+}
+
+// isDirectiveKey reports whether k is a directive key of a built-in or registered directive.
+func isDirectiveKey(k string) bool {
+	for _, s := range builtinSuffixes {
+		if strings.HasSuffix(k, s) {
+			return true
+		}
+	}
+	directivesMu.RLock()
+	defer directivesMu.RUnlock()
+	for _, d := range directives {
+		if strings.HasSuffix(k, d.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeState is the input and output of the phases of one merge.
+type mergeState struct {
+	merged  map[string]interface{}
+	new     map[string]interface{}
+	newCopy map[string]interface{} // The new config merged in place; its nested maps are shared with merged
+	old     map[string]interface{}
+	r       *reporter
+	t       *tracer
+}
+
+type step struct {
+	phase Phase
+	apply func(s *mergeState)
+}
+
+var builtinSteps = []step{
+	{PhaseDeprecated, func(s *mergeState) { applyDeprecatedInto(s.merged, s.newCopy, s.old, s.r, s.t, "") }},
+	{PhaseCast, func(s *mergeState) { applyDeprecatedCastInto(s.merged, s.new, s.old, s.r, s.t, "") }},
+	{PhaseMap, func(s *mergeState) { applyDeprecatedMapInto(s.merged, s.new, s.old, s.r, s.t, "") }},
+	{PhaseExpand, func(s *mergeState) { applyDeprecatedExpandInto(s.merged, s.new, s.old, s.r, s.t, "") }},
+	{PhaseCollapse, func(s *mergeState) { applyDeprecatedCollapseInto(s.merged, s.merged, s.new, s.old, s.r, s.t, "") }},
+	{PhaseConcat, func(s *mergeState) { applyDeprecatedConcatInto(s.merged, s.merged, s.new, s.r, s.t, "") }},
+	// Use original new so _replace sees the intended new values (merge overwrote newCopy).
+	{PhaseReplace, func(s *mergeState) { applyReplaceInto(s.merged, s.new, s.r, s.t, "") }},
+	{PhaseRemove, func(s *mergeState) { applyDeprecatedRemoveInto(s.merged, s.merged, s.new, s.r, "") }},
+}
+
+// pipeline returns the built-in and registered directives in the order they are applied.
+func pipeline() []step {
+	directivesMu.RLock()
+	defer directivesMu.RUnlock()
+
+	steps := append([]step(nil), builtinSteps...)
+	for _, d := range directives {
+		d := d
+		steps = append(steps, step{d.phase, func(s *mergeState) {
+			applyDirectiveInto(s, d, s.merged, s.new, "")
+			deleteSuffixKeys(s.merged, d.suffix)
+		}})
+	}
+	sort.SliceStable(steps, func(i, j int) bool { return steps[i].phase < steps[j].phase })
+	return steps
+}
+
+// applyDirectiveInto calls the handler of d for every key of new ending with its suffix; m is the map of the
+// merged config at prefix.
+func applyDirectiveInto(s *mergeState, d directive, m, new map[string]interface{}, prefix string) {
+	for k, v := range new {
+		if !strings.HasSuffix(k, d.suffix) {
+			if newMap, ok := v.(map[string]interface{}); ok && !isDirectiveKey(k) {
+				if mChild, ok := m[k].(map[string]interface{}); ok {
+					applyDirectiveInto(s, d, mChild, newMap, joinKey(prefix, k))
+				}
+			}
+			continue
+		}
+
+		ctx := &DirectiveContext{
+			Old:    s.old,
+			New:    s.new,
+			Merged: s.merged,
+			Parent: m,
+			Path:   prefix,
+			Key:    k,
+			Target: strings.TrimSuffix(k, d.suffix),
+			Value:  v,
+		}
+		var before interface{}
+		if s.t != nil {
+			before = deepCopyValue(m[ctx.Target])
+		}
+		if err := d.fn(ctx); err != nil {
+			s.r.add(prefix, k, v, err.Error())
+			continue
+		}
+		if s.t != nil && !reflect.DeepEqual(before, m[ctx.Target]) {
+			s.t.record(ctx.TargetPath(), SourceDirective, prefix, k, v)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package merger
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DirectiveError is a directive of the new config that could not be applied.
+type DirectiveError struct {
+	// Key — dotted path of the directive key, e.g. "db.dsn_deprecated".
+	Key string
+
+	// Path — the value of the directive: a source path or spec.
+	Path string
+
+	// Reason — why the directive was not applied.
+	Reason string
+}
+
+func (e DirectiveError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s", e.Key, e.Reason)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Key, e.Reason, e.Path)
+}
+
+// DirectiveErrors is returned by MergeWithErrors in strict mode with every directive that could not be applied.
+type DirectiveErrors []DirectiveError
+
+func (e DirectiveErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	if len(msgs) == 1 {
+		return "unresolved directive: " + msgs[0]
+	}
+	return fmt.Sprintf("%d unresolved directives: %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+// reporter collects the directives that could not be applied. A nil reporter discards them.
+type reporter struct {
+	errs DirectiveErrors
+	// oldEmpty is true when there is no old config, e.g. on the first migration; missing source paths are expected then.
+	oldEmpty bool
+}
+
+// add records a problem with the directive key below prefix.
+func (r *reporter) add(prefix, key string, value interface{}, reason string) {
+	if r == nil {
+		return
+	}
+	path := ""
+	if value != nil {
+		path = fmt.Sprint(value)
+	}
+	err := DirectiveError{Key: joinKey(prefix, key), Path: path, Reason: reason}
+	// Nested _deprecated maps are visited once for the key and once for the directive
+	for _, e := range r.errs {
+		if e == err {
+			return
+		}
+	}
+	r.errs = append(r.errs, err)
+}
+
+// missing records a source path that does not exist in the old config, unless there is no old config.
+func (r *reporter) missing(prefix, key, path string) {
+	if r == nil || r.oldEmpty {
+		return
+	}
+	r.add(prefix, key, path, "path not found in old config")
+}
+
+// joinKey returns the dotted path of key below prefix.
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
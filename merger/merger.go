@@ -3,6 +3,7 @@ package merger
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -27,23 +28,117 @@ const deprecatedReplaceSuffix = "_deprecated_replace"
 // template as {0}, {1}, ... and the result is written to the target key.
 const deprecatedConcatSuffix = "_deprecated_concat"
 
+// Keys ending with _deprecated_remove remove keys from the result: with an empty value the sibling target key,
+// otherwise the comma-separated paths of the value, resolved against the root of the result. A "*" segment
+// matches every key of a map or element of an array, a number selects an array element.
+const deprecatedRemoveSuffix = "_deprecated_remove"
+
+// Keys ending with _merge_key: value is "field" or "field,drop" — elements of the sibling array of objects
+// are matched with the old elements by the value of field instead of by position. Matched elements are merged,
+// new elements are added and old elements missing from the new array are kept at the end, unless "drop" is given.
+const mergeKeySuffix = "_merge_key"
+
+// Keys ending with _merge_mode: value is an ArrayMode — the sibling array is combined with the old one in that mode
+// instead of the default of Options.ArrayMode.
+const mergeModeSuffix = "_merge_mode"
+
+// ArrayMode defines how an array of the new config is combined with the array of the old config.
+type ArrayMode string
+
+const (
+	// ArrayMerge keeps old arrays of scalars and merges every old object onto the first object of the new array.
+	ArrayMerge ArrayMode = "merge"
+	// ArrayReplace uses the new array.
+	ArrayReplace ArrayMode = "replace"
+	// ArrayAppend keeps the old array and appends the new elements it does not contain yet.
+	ArrayAppend ArrayMode = "append"
+	// ArrayUnion keeps every distinct element of the old and the new array once, in order of first occurrence.
+	ArrayUnion ArrayMode = "union"
+	// ArrayKeep keeps the old array.
+	ArrayKeep ArrayMode = "keep"
+)
+
+// ParseArrayMode returns the ArrayMode named s; "" is ArrayMerge.
+func ParseArrayMode(s string) (ArrayMode, error) {
+	switch mode := ArrayMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return ArrayMerge, nil
+	case ArrayMerge, ArrayReplace, ArrayAppend, ArrayUnion, ArrayKeep:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unknown array merge mode %q", s)
+}
+
+// Options change how Merge combines the new and the old config.
+type Options struct {
+	// ArrayMode — how arrays are combined unless a key sets its own with _merge_mode. Defaults to ArrayMerge.
+	ArrayMode ArrayMode
+
+	// Strict — MergeWithErrors returns DirectiveErrors for directives that cannot be applied, such as a
+	// _deprecated path that does not exist in the old config or a spec that cannot be parsed.
+	Strict bool
+}
+
+// Merge combines the new config with the old one using the default Options.
 func Merge(new, old map[string]interface{}) map[string]interface{} {
+	return MergeWithOptions(new, old, Options{})
+}
+
+// MergeWithOptions combines the new config with the old one: new defines the keys, old values of the same type
+// are kept, and the directive keys of new are applied.
+func MergeWithOptions(new, old map[string]interface{}, opts Options) map[string]interface{} {
+	m, _ := MergeWithErrors(new, old, opts)
+	return m
+}
+
+// MergeWithErrors is MergeWithOptions that, with Options.Strict, also returns DirectiveErrors listing the directives
+// that could not be applied. The merged config is returned either way. Source paths missing from an empty old
+// config are not reported, so a first migration onto an empty file does not fail.
+func MergeWithErrors(new, old map[string]interface{}, opts Options) (map[string]interface{}, error) {
+	return merge(new, old, opts, nil)
+}
+
+// merge combines the new config with the old one, recording the paths written by directives in t.
+func merge(new, old map[string]interface{}, opts Options, t *tracer) (map[string]interface{}, error) {
+	mode := opts.ArrayMode
+	if mode == "" {
+		mode = ArrayMerge
+	}
+
+	var r *reporter
+	if opts.Strict {
+		r = &reporter{oldEmpty: len(old) == 0}
+		checkArrayDirectives(new, "", r)
+	}
+
 	newCopy := deepCopyMap(new)
-	m := mergeMaps(newCopy, old)
-	applyDeprecatedInto(m, newCopy, old)
-	applyDeprecatedExpandInto(m, new, old)
-	applyDeprecatedCollapseInto(m, m, new, old)
-	applyDeprecatedConcatInto(m, m, new)
-	// Use original new so _replace sees the intended new values (merge overwrote newCopy).
-	applyReplaceInto(m, new)
+	m := mergeMaps(newCopy, old, mode)
+	s := &mergeState{merged: m, new: new, newCopy: newCopy, old: old, r: r, t: t}
+	for _, st := range pipeline() {
+		st.apply(s)
+	}
+	deleteSuffixKeys(m, mergeKeySuffix)
+	deleteSuffixKeys(m, mergeModeSuffix)
 
 	if replacer.HasReplacers() {
+		if t != nil {
+			t.beforeReplace = deepCopyMap(m)
+		}
 		for k, v := range m {
 			m[k] = replace(v)
 		}
 	}
 
-	return m
+	if r != nil && len(r.errs) > 0 {
+		sort.Slice(r.errs, func(i, j int) bool {
+			if r.errs[i].Key != r.errs[j].Key {
+				return r.errs[i].Key < r.errs[j].Key
+			}
+			return r.errs[i].Path < r.errs[j].Path
+		})
+		return m, r.errs
+	}
+	return m, nil
 }
 
 // deepCopyMap recursively copies a map (and nested maps/slices) so merge can mutate the copy.
@@ -176,26 +271,28 @@ func appendSlice(a []interface{}, els ...interface{}) []interface{} {
 
 // applyDeprecatedInto applies deprecated rules into m in place: for each *_deprecated in new,
 // pulls value from old by path and merges into m[targetKey]. Uses root old for paths.
-func applyDeprecatedInto(m, new, old map[string]interface{}) {
+func applyDeprecatedInto(m, new, old map[string]interface{}, r *reporter, t *tracer, prefix string) {
 	if m == nil || new == nil || old == nil {
 		return
 	}
 	for k, v := range new {
 		if !strings.HasSuffix(k, deprecatedSuffix) {
-			if newMap, ok := v.(map[string]interface{}); ok {
+			if newMap, ok := v.(map[string]interface{}); ok && !isDirectiveKey(k) {
 				if mChild, ok := m[k].(map[string]interface{}); ok {
-					applyDeprecatedInto(mChild, newMap, old)
+					applyDeprecatedInto(mChild, newMap, old, r, t, joinKey(prefix, k))
 				}
 			}
 			continue
 		}
 		path, ok := v.(string)
 		if !ok {
+			r.add(prefix, k, v, "value must be a path")
 			continue
 		}
 		targetKey := strings.TrimSuffix(k, deprecatedSuffix)
 		deprecatedVal, found := getValueByPath(old, path)
 		if !found {
+			r.missing(prefix, k, path)
 			continue
 		}
 		oldTarget, _ := getValueByPath(old, targetKey)
@@ -208,11 +305,12 @@ func applyDeprecatedInto(m, new, old map[string]interface{}) {
 			// Nested object: m[targetKey] already has new structure from merge; just recurse to apply inner _deprecated
 			mChild, _ := m[targetKey].(map[string]interface{})
 			if mChild != nil {
-				applyDeprecatedInto(mChild, newMap, old)
+				applyDeprecatedInto(mChild, newMap, old, r, t, joinKey(prefix, targetKey))
 			}
 		} else {
 			merged := mergeDeprecatedIntoTarget(oldTarget, deprecatedVal, newTarget)
 			m[targetKey] = merged
+			t.record(joinKey(prefix, targetKey), SourceDeprecated, prefix, k, path)
 		}
 	}
 	// Remove _deprecated keys from m
@@ -234,7 +332,7 @@ func parseExpandSpec(s string) (path, field string) {
 
 // applyDeprecatedExpandInto handles key_deprecated_expand: "path->field". Source array at path in old
 // is merged with template array from new[targetKey]: for each index i, result[i] = template[i] with [field] = source[i].
-func applyDeprecatedExpandInto(m, new, old map[string]interface{}) {
+func applyDeprecatedExpandInto(m, new, old map[string]interface{}, r *reporter, t *tracer, prefix string) {
 	if m == nil || new == nil || old == nil {
 		return
 	}
@@ -242,21 +340,21 @@ func applyDeprecatedExpandInto(m, new, old map[string]interface{}) {
 		if !strings.HasSuffix(k, deprecatedExpandSuffix) {
 			continue
 		}
-		spec, ok := v.(string)
-		if !ok {
-			continue
-		}
+		spec, _ := v.(string)
 		path, field := parseExpandSpec(spec)
 		if path == "" || field == "" {
+			r.add(prefix, k, v, `value must be "path->field"`)
 			continue
 		}
 		targetKey := strings.TrimSuffix(k, deprecatedExpandSuffix)
 		sourceVal, found := getValueByPath(old, path)
 		if !found {
+			r.missing(prefix, k, path)
 			continue
 		}
 		sourceArr, ok := toSlice(sourceVal)
 		if !ok {
+			r.add(prefix, k, path, "source is not an array")
 			continue
 		}
 		templateVal := new[targetKey]
@@ -283,6 +381,7 @@ func applyDeprecatedExpandInto(m, new, old map[string]interface{}) {
 			result = append(result, obj)
 		}
 		m[targetKey] = result
+		t.record(joinKey(prefix, targetKey), SourceExpand, prefix, k, spec)
 	}
 	for k := range m {
 		if strings.HasSuffix(k, deprecatedExpandSuffix) {
@@ -291,12 +390,12 @@ func applyDeprecatedExpandInto(m, new, old map[string]interface{}) {
 	}
 	// Recurse into nested maps
 	for k, v := range new {
-		if strings.HasSuffix(k, deprecatedExpandSuffix) || strings.HasSuffix(k, deprecatedSuffix) || strings.HasSuffix(k, deprecatedReplaceSuffix) {
+		if isDirectiveKey(k) {
 			continue
 		}
 		if newMap, ok := v.(map[string]interface{}); ok {
 			if mChild, ok := m[k].(map[string]interface{}); ok {
-				applyDeprecatedExpandInto(mChild, newMap, old)
+				applyDeprecatedExpandInto(mChild, newMap, old, r, t, joinKey(prefix, k))
 			}
 		}
 	}
@@ -325,7 +424,7 @@ func parseCollapseSpec(s string) (arrayPath, field, targetPath string) {
 
 // applyDeprecatedCollapseInto handles key_deprecated_collapse: "arrayPath.field->targetPath".
 // Reads array at arrayPath from old, extracts field from each element, writes []scalars at targetPath in rootM.
-func applyDeprecatedCollapseInto(rootM, m, new, old map[string]interface{}) {
+func applyDeprecatedCollapseInto(rootM, m, new, old map[string]interface{}, r *reporter, t *tracer, prefix string) {
 	if rootM == nil || m == nil || new == nil || old == nil {
 		return
 	}
@@ -333,20 +432,20 @@ func applyDeprecatedCollapseInto(rootM, m, new, old map[string]interface{}) {
 		if !strings.HasSuffix(k, deprecatedCollapseSuffix) {
 			continue
 		}
-		spec, ok := v.(string)
-		if !ok {
-			continue
-		}
+		spec, _ := v.(string)
 		arrayPath, field, targetPath := parseCollapseSpec(spec)
 		if arrayPath == "" || field == "" || targetPath == "" {
+			r.add(prefix, k, v, `value must be "arrayPath.field->targetPath"`)
 			continue
 		}
 		sourceVal, found := getValueByPath(old, arrayPath)
 		if !found {
+			r.missing(prefix, k, arrayPath)
 			continue
 		}
 		sourceArr, ok := toSlice(sourceVal)
 		if !ok {
+			r.add(prefix, k, arrayPath, "source is not an array")
 			continue
 		}
 		result := make([]interface{}, 0, len(sourceArr))
@@ -360,6 +459,7 @@ func applyDeprecatedCollapseInto(rootM, m, new, old map[string]interface{}) {
 			}
 		}
 		setValueByPath(rootM, targetPath, result)
+		t.record(targetPath, SourceCollapse, prefix, k, spec)
 	}
 	for k := range m {
 		if strings.HasSuffix(k, deprecatedCollapseSuffix) {
@@ -368,12 +468,12 @@ func applyDeprecatedCollapseInto(rootM, m, new, old map[string]interface{}) {
 	}
 	// Recurse into nested maps
 	for k, v := range new {
-		if strings.HasSuffix(k, deprecatedCollapseSuffix) || strings.HasSuffix(k, deprecatedExpandSuffix) || strings.HasSuffix(k, deprecatedSuffix) || strings.HasSuffix(k, deprecatedReplaceSuffix) {
+		if isDirectiveKey(k) {
 			continue
 		}
 		if newMap, ok := v.(map[string]interface{}); ok {
 			if mChild, ok := m[k].(map[string]interface{}); ok {
-				applyDeprecatedCollapseInto(rootM, mChild, newMap, old)
+				applyDeprecatedCollapseInto(rootM, mChild, newMap, old, r, t, joinKey(prefix, k))
 			}
 		}
 	}
@@ -399,7 +499,7 @@ func parseConcatSpec(s string) (paths []string, template string) {
 // applyDeprecatedConcatInto applies key_deprecated_concat: "path1,path2->template". Paths are resolved
 // against rootM (the merged map after _deprecated/_deprecated_expand/_deprecated_collapse), so concat
 // glues already-transformed fields. Template uses {0}, {1}, ...; result is written to target key in m.
-func applyDeprecatedConcatInto(rootM, m, new map[string]interface{}) {
+func applyDeprecatedConcatInto(rootM, m, new map[string]interface{}, r *reporter, t *tracer, prefix string) {
 	if rootM == nil || m == nil || new == nil {
 		return
 	}
@@ -407,18 +507,17 @@ func applyDeprecatedConcatInto(rootM, m, new map[string]interface{}) {
 		if !strings.HasSuffix(k, deprecatedConcatSuffix) {
 			continue
 		}
-		spec, ok := v.(string)
-		if !ok {
-			continue
-		}
+		spec, _ := v.(string)
 		paths, template := parseConcatSpec(spec)
 		if len(paths) == 0 || template == "" {
+			r.add(prefix, k, v, `value must be "path1,path2->template"`)
 			continue
 		}
 		var parts []string
 		for _, path := range paths {
 			val, found := getValueByPath(rootM, path)
 			if !found {
+				r.add(prefix, k, path, "path not found in merged config")
 				parts = append(parts, "")
 				continue
 			}
@@ -430,6 +529,7 @@ func applyDeprecatedConcatInto(rootM, m, new map[string]interface{}) {
 		}
 		targetKey := strings.TrimSuffix(k, deprecatedConcatSuffix)
 		m[targetKey] = result
+		t.record(joinKey(prefix, targetKey), SourceConcat, prefix, k, spec)
 	}
 	for k := range m {
 		if strings.HasSuffix(k, deprecatedConcatSuffix) {
@@ -438,14 +538,12 @@ func applyDeprecatedConcatInto(rootM, m, new map[string]interface{}) {
 	}
 	// Recurse into nested maps
 	for k, v := range new {
-		if strings.HasSuffix(k, deprecatedConcatSuffix) || strings.HasSuffix(k, deprecatedCollapseSuffix) ||
-			strings.HasSuffix(k, deprecatedExpandSuffix) || strings.HasSuffix(k, deprecatedSuffix) ||
-			strings.HasSuffix(k, deprecatedReplaceSuffix) {
+		if isDirectiveKey(k) {
 			continue
 		}
 		if newMap, ok := v.(map[string]interface{}); ok {
 			if mChild, ok := m[k].(map[string]interface{}); ok {
-				applyDeprecatedConcatInto(rootM, mChild, newMap)
+				applyDeprecatedConcatInto(rootM, mChild, newMap, r, t, joinKey(prefix, k))
 			}
 		}
 	}
@@ -455,7 +553,7 @@ func applyDeprecatedConcatInto(rootM, m, new map[string]interface{}) {
 // set m[key] = new[key] (the target key's value in the new config), so the new value
 // overwrites whatever the merge kept. The _replace key is just a marker (e.g. empty);
 // the actual value is taken from the sibling key in new.
-func applyReplaceInto(m, new map[string]interface{}) {
+func applyReplaceInto(m, new map[string]interface{}, r *reporter, t *tracer, prefix string) {
 	if m == nil || new == nil {
 		return
 	}
@@ -464,13 +562,16 @@ func applyReplaceInto(m, new map[string]interface{}) {
 			targetKey := strings.TrimSuffix(k, deprecatedReplaceSuffix)
 			if newVal, exists := new[targetKey]; exists {
 				m[targetKey] = newVal
+				t.record(joinKey(prefix, targetKey), SourceReplace, prefix, k, nil)
+			} else {
+				r.add(prefix, k, nil, "no key "+targetKey+" to replace")
 			}
 			continue
 		}
 		v := new[k]
 		if newMap, ok := v.(map[string]interface{}); ok {
 			if mChild, ok := m[k].(map[string]interface{}); ok {
-				applyReplaceInto(mChild, newMap)
+				applyReplaceInto(mChild, newMap, r, t, joinKey(prefix, k))
 			}
 		}
 	}
@@ -481,7 +582,122 @@ func applyReplaceInto(m, new map[string]interface{}) {
 	}
 }
 
-func mergeMaps(out, old map[string]interface{}) map[string]interface{} {
+// applyDeprecatedRemoveInto applies key_deprecated_remove: an empty value removes m[key], otherwise every
+// path of the comma-separated value is removed from rootM. Indices of array elements refer to the arrays before any
+// path of the value is removed. Missing paths are ignored.
+func applyDeprecatedRemoveInto(rootM, m, new map[string]interface{}, r *reporter, prefix string) {
+	if rootM == nil || m == nil || new == nil {
+		return
+	}
+	for k, v := range new {
+		if !strings.HasSuffix(k, deprecatedRemoveSuffix) {
+			continue
+		}
+		delete(m, k)
+		spec, ok := v.(string)
+		if !ok && v != nil {
+			r.add(prefix, k, v, "value must be empty or a list of paths")
+			continue
+		}
+		if strings.TrimSpace(spec) == "" {
+			delete(m, strings.TrimSuffix(k, deprecatedRemoveSuffix))
+			continue
+		}
+		for _, path := range strings.Split(spec, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				removeByPath(rootM, strings.Split(path, "."))
+			}
+		}
+		compactRemoved(rootM)
+	}
+	// Recurse into nested maps
+	for k, v := range new {
+		if strings.HasSuffix(k, deprecatedRemoveSuffix) {
+			continue
+		}
+		if newMap, ok := v.(map[string]interface{}); ok {
+			if mChild, ok := m[k].(map[string]interface{}); ok {
+				applyDeprecatedRemoveInto(rootM, mChild, newMap, r, joinKey(prefix, k))
+			}
+		}
+	}
+}
+
+// removed stands for an array element removed by removeByPath until compactRemoved drops it, so that the indices of
+// the other paths of the same _deprecated_remove value still refer to the elements before the removal.
+type removed struct{}
+
+// removeByPath removes the values at path below node and returns node. "*" matches all keys or elements, a number an
+// array element. Removed array elements are replaced with removed{}.
+func removeByPath(node interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return node
+	}
+	key, last := path[0], len(path) == 1
+
+	switch t := node.(type) {
+	case map[string]interface{}:
+		if key == "*" {
+			for k, child := range t {
+				if last {
+					delete(t, k)
+				} else {
+					t[k] = removeByPath(child, path[1:])
+				}
+			}
+			return t
+		}
+		if last {
+			delete(t, key)
+			return t
+		}
+		if child, ok := t[key]; ok {
+			t[key] = removeByPath(child, path[1:])
+		}
+	case []interface{}, []map[string]interface{}:
+		// The array may be shared with the old config
+		src, _ := toSlice(t)
+		arr := append([]interface{}(nil), src...)
+		for i, child := range arr {
+			if key != "*" && key != strconv.Itoa(i) {
+				continue
+			}
+			if last {
+				arr[i] = removed{}
+			} else {
+				arr[i] = removeByPath(child, path[1:])
+			}
+		}
+		return arr
+	}
+	return node
+}
+
+// compactRemoved drops the array elements replaced by removeByPath below node and returns node.
+func compactRemoved(node interface{}) interface{} {
+	switch t := node.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			t[k] = compactRemoved(child)
+		}
+	case []interface{}:
+		out := make([]interface{}, 0, len(t))
+		for _, child := range t {
+			if _, ok := child.(removed); !ok {
+				out = append(out, compactRemoved(child))
+			}
+		}
+		if len(out) == len(t) {
+			copy(t, out)
+			return t
+		}
+		return out
+	}
+	return node
+}
+
+// mergeMaps merges old into out in place; mode is the default ArrayMode for arrays.
+func mergeMaps(out, old map[string]interface{}, mode ArrayMode) map[string]interface{} {
 	if len(out) == 0 {
 		return map[string]interface{}{}
 	}
@@ -495,14 +711,144 @@ func mergeMaps(out, old map[string]interface{}) map[string]interface{} {
 			continue
 		}
 
-		out[key] = mergeValues(newVal, oldVal)
+		if spec, ok := out[key+mergeKeySuffix].(string); ok {
+			if merged, ok := mergeArraysByKey(newVal, oldVal, spec, mode); ok {
+				out[key] = merged
+				continue
+			}
+		}
+
+		if spec, ok := out[key+mergeModeSuffix].(string); ok {
+			newArr, newIsArr := newVal.([]interface{})
+			oldArr, oldIsArr := oldVal.([]interface{})
+			if keyMode, err := ParseArrayMode(spec); err == nil && newIsArr && oldIsArr {
+				out[key] = combineArrays(newArr, oldArr, keyMode, mode)
+				continue
+			}
+		}
+
+		out[key] = mergeValues(newVal, oldVal, mode)
 	}
 
 	return out
 }
 
+// parseMergeKeySpec parses "field" or "field,drop" and returns field and whether unmatched old elements are dropped.
+func parseMergeKeySpec(s string) (field string, drop bool) {
+	parts := strings.Split(s, ",")
+	field = strings.TrimSpace(parts[0])
+	for _, opt := range parts[1:] {
+		if strings.TrimSpace(opt) == "drop" {
+			drop = true
+		}
+	}
+	return field, drop
+}
+
+// mergeArraysByKey merges two arrays of objects by the identity field of spec, keeping the order of newVal.
+// It returns false if either value is not an array or spec has no field.
+func mergeArraysByKey(newVal, oldVal interface{}, spec string, mode ArrayMode) (interface{}, bool) {
+	field, drop := parseMergeKeySpec(spec)
+	newArr, newIsArr := toSlice(newVal)
+	oldArr, oldIsArr := toSlice(oldVal)
+	if field == "" || !newIsArr || !oldIsArr {
+		return nil, false
+	}
+
+	identity := func(elem interface{}) (string, bool) {
+		obj, ok := elem.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		v, ok := obj[field]
+		if !ok || v == nil {
+			return "", false
+		}
+		return fmt.Sprint(v), true
+	}
+
+	// Old elements by identity; duplicates are matched in order
+	byKey := map[string][]int{}
+	for i, elem := range oldArr {
+		if id, ok := identity(elem); ok {
+			byKey[id] = append(byKey[id], i)
+		}
+	}
+
+	matched := make([]bool, len(oldArr))
+	result := make([]interface{}, 0, len(newArr)+len(oldArr))
+	for _, elem := range newArr {
+		id, ok := identity(elem)
+		if !ok || len(byKey[id]) == 0 {
+			result = append(result, deepCopyValue(elem))
+			continue
+		}
+		i := byKey[id][0]
+		byKey[id] = byKey[id][1:]
+		matched[i] = true
+		result = append(result, mergeValues(deepCopyValue(elem), oldArr[i], mode))
+	}
+
+	if !drop {
+		for i, elem := range oldArr {
+			if !matched[i] {
+				result = append(result, elem)
+			}
+		}
+	}
+	return result, true
+}
+
+// checkArrayDirectives reports _merge_key and _merge_mode directives of new that cannot be applied.
+func checkArrayDirectives(new map[string]interface{}, prefix string, r *reporter) {
+	for k, v := range new {
+		switch {
+		case strings.HasSuffix(k, mergeKeySuffix):
+			spec, _ := v.(string)
+			if field, _ := parseMergeKeySpec(spec); field == "" {
+				r.add(prefix, k, v, `value must be "field" or "field,drop"`)
+			} else if _, ok := toSlice(new[strings.TrimSuffix(k, mergeKeySuffix)]); !ok {
+				r.add(prefix, k, nil, "target is not an array")
+			}
+		case strings.HasSuffix(k, mergeModeSuffix):
+			spec, _ := v.(string)
+			if _, err := ParseArrayMode(spec); err != nil || spec == "" {
+				r.add(prefix, k, v, "unknown array merge mode")
+			} else if _, ok := toSlice(new[strings.TrimSuffix(k, mergeModeSuffix)]); !ok {
+				r.add(prefix, k, nil, "target is not an array")
+			}
+		default:
+			if newMap, ok := v.(map[string]interface{}); ok {
+				checkArrayDirectives(newMap, joinKey(prefix, k), r)
+			}
+		}
+	}
+}
+
+// deleteSuffixKeys removes the keys ending with suffix from all maps in v.
+func deleteSuffixKeys(v interface{}, suffix string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, child := range t {
+			if strings.HasSuffix(k, suffix) {
+				delete(t, k)
+				continue
+			}
+			deleteSuffixKeys(child, suffix)
+		}
+	case []interface{}:
+		for _, child := range t {
+			deleteSuffixKeys(child, suffix)
+		}
+	case []map[string]interface{}:
+		for _, child := range t {
+			deleteSuffixKeys(child, suffix)
+		}
+	}
+}
+
 // mergeValues returns the merged value for one key. Precedence: both maps → recurse; both arrays → merge; same type → old; nils handled.
-func mergeValues(newVal, oldVal interface{}) interface{} {
+func mergeValues(newVal, oldVal interface{}, mode ArrayMode) interface{} {
 	newMap, newIsMap := newVal.(map[string]interface{})
 	oldMap, oldIsMap := oldVal.(map[string]interface{})
 	newArr, newIsArr := newVal.([]interface{})
@@ -510,10 +856,10 @@ func mergeValues(newVal, oldVal interface{}) interface{} {
 
 	switch {
 	case newIsMap && oldIsMap:
-		return mergeMaps(newMap, oldMap)
+		return mergeMaps(newMap, oldMap, mode)
 
 	case newIsArr && oldIsArr:
-		return mergeArrays(newArr, oldArr)
+		return combineArrays(newArr, oldArr, mode, mode)
 
 	case isSameType(newVal, oldVal):
 		return oldVal
@@ -528,7 +874,44 @@ func mergeValues(newVal, oldVal interface{}) interface{} {
 	return newVal
 }
 
-func mergeArrays(newArr, oldArr []interface{}) interface{} {
+// combineArrays combines newArr and oldArr in mode; def is the default ArrayMode for arrays nested in their objects.
+func combineArrays(newArr, oldArr []interface{}, mode, def ArrayMode) interface{} {
+	switch mode {
+	case ArrayReplace:
+		return deepCopyValue(newArr)
+	case ArrayKeep:
+		return oldArr
+	case ArrayAppend:
+		result := append([]interface{}{}, oldArr...)
+		for _, elem := range newArr {
+			if !containsValue(oldArr, elem) {
+				result = append(result, deepCopyValue(elem))
+			}
+		}
+		return result
+	case ArrayUnion:
+		result := make([]interface{}, 0, len(oldArr)+len(newArr))
+		for _, elem := range append(append([]interface{}{}, oldArr...), newArr...) {
+			if !containsValue(result, elem) {
+				result = append(result, deepCopyValue(elem))
+			}
+		}
+		return result
+	}
+	return mergeArrays(newArr, oldArr, def)
+}
+
+// containsValue reports whether arr contains an element deeply equal to v.
+func containsValue(arr []interface{}, v interface{}) bool {
+	for _, elem := range arr {
+		if reflect.DeepEqual(elem, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeArrays(newArr, oldArr []interface{}, mode ArrayMode) interface{} {
 	if len(newArr) == 0 || len(oldArr) == 0 {
 		return oldArr
 	}
@@ -542,10 +925,10 @@ func mergeArrays(newArr, oldArr []interface{}) interface{} {
 		oldElem, oldIsMap := oldItem.(map[string]interface{})
 		if oldIsMap {
 			templateCopy := deepCopyMap(newElem)
-			result = append(result, mergeMaps(templateCopy, oldElem))
+			result = append(result, mergeMaps(templateCopy, oldElem, mode))
 		} else if i < len(newArr) {
 			templateCopy := deepCopyMap(newElem)
-			result = append(result, mergeMaps(templateCopy, templateCopy))
+			result = append(result, mergeMaps(templateCopy, templateCopy, mode))
 		}
 	}
 	return result
@@ -571,10 +954,10 @@ func replace(value interface{}) interface{} {
 }
 
 func isSameType(a, b interface{}) bool {
+	if isString(a) {
+		return isString(b)
+	}
 	switch a.(type) {
-	case string:
-		_, ok := b.(string)
-		return ok
 	case int:
 		_, ok := b.(int)
 		return ok
@@ -594,3 +977,8 @@ func isSameType(a, b interface{}) bool {
 		return false
 	}
 }
+
+// isString reports whether v is a string or of a named string type, such as an expression kept by a driver.
+func isString(v interface{}) bool {
+	return v != nil && reflect.TypeOf(v).Kind() == reflect.String
+}
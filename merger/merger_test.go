@@ -2,7 +2,11 @@ package merger
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/c2pc/config-migrate/replacer"
@@ -11,6 +15,9 @@ import (
 // TestMergeMaps covers the core merge behaviour: empty inputs, nil handling,
 // and that when a key exists in both configs we prefer the old value (or merge
 // maps/arrays as defined).
+// expression is a named string type, like the expressions kept by the HCL driver.
+type expression string
+
 func TestMergeMaps(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -484,6 +491,21 @@ func TestMergeMaps(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Named string type is a string",
+			oldMap: map[string]interface{}{
+				"addr": expression(`"${attr.unique.network.ip-address}"`),
+				"port": "4646",
+			},
+			newMap: map[string]interface{}{
+				"addr": "0.0.0.0",
+				"port": expression("var.port"),
+			},
+			expected: map[string]interface{}{
+				"addr": `"${attr.unique.network.ip-address}"`,
+				"port": "4646",
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -909,6 +931,605 @@ func TestMergeConcatKeys(t *testing.T) {
 	}
 }
 
+// TestMergeRemoveKeys checks key_deprecated_remove: an empty value removes the sibling key, otherwise the
+// listed root paths are removed from the merged map, with "*" matching all map keys and array elements.
+func TestMergeRemoveKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		oldMap   map[string]interface{}
+		newMap   map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name: "empty value removes sibling key",
+			oldMap: map[string]interface{}{
+				"host":     "localhost",
+				"obsolete": true,
+			},
+			newMap: map[string]interface{}{
+				"host":                       "",
+				"obsolete":                   false,
+				"obsolete_deprecated_remove": "",
+			},
+			expected: map[string]interface{}{
+				"host": "localhost",
+			},
+		},
+		{
+			name: "nested sibling key",
+			oldMap: map[string]interface{}{
+				"database": map[string]interface{}{"dsn": "old-dsn", "pool": 5},
+			},
+			newMap: map[string]interface{}{
+				"database": map[string]interface{}{
+					"dsn":                    "",
+					"pool":                   1,
+					"pool_deprecated_remove": nil,
+				},
+			},
+			expected: map[string]interface{}{
+				"database": map[string]interface{}{"dsn": "old-dsn"},
+			},
+		},
+		{
+			name: "wildcard inside array elements kept from old",
+			oldMap: map[string]interface{}{
+				"servers": []interface{}{
+					map[string]interface{}{"host": "a", "legacy_tls": true},
+					map[string]interface{}{"host": "b"},
+				},
+			},
+			newMap: map[string]interface{}{
+				"servers":                   []interface{}{},
+				"cleanup_deprecated_remove": "servers.*.legacy_tls",
+			},
+			expected: map[string]interface{}{
+				"servers": []interface{}{
+					map[string]interface{}{"host": "a"},
+					map[string]interface{}{"host": "b"},
+				},
+			},
+		},
+		{
+			name: "several paths, wildcard map keys and array index",
+			oldMap: map[string]interface{}{
+				"tenants": map[string]interface{}{
+					"acme":   map[string]interface{}{"quota": 1, "beta": true},
+					"globex": map[string]interface{}{"quota": 2, "beta": false},
+				},
+				"hosts": []interface{}{
+					map[string]interface{}{"name": "a", "weight": 1},
+					map[string]interface{}{"name": "b", "weight": 2},
+				},
+			},
+			newMap: map[string]interface{}{
+				"tenants": map[string]interface{}{
+					"acme":   map[string]interface{}{"quota": 0, "beta": false},
+					"globex": map[string]interface{}{"quota": 0, "beta": false},
+				},
+				"hosts":                     []interface{}{"x"},
+				"cleanup_deprecated_remove": "tenants.*.beta, hosts.1.weight, missing.path",
+			},
+			expected: map[string]interface{}{
+				"hosts": []interface{}{
+					map[string]interface{}{"name": "a", "weight": 1},
+					map[string]interface{}{"name": "b"},
+				},
+				"tenants": map[string]interface{}{
+					"acme":   map[string]interface{}{"quota": 1},
+					"globex": map[string]interface{}{"quota": 2},
+				},
+			},
+		},
+		{
+			name: "array elements by index",
+			oldMap: map[string]interface{}{
+				"servers": []interface{}{
+					map[string]interface{}{"host": "a"},
+					map[string]interface{}{"host": "b"},
+					map[string]interface{}{"host": "c"},
+				},
+				"ports": []interface{}{80, 443, 8080},
+			},
+			newMap: map[string]interface{}{
+				"servers":                   []interface{}{},
+				"ports":                     []interface{}{},
+				"cleanup_deprecated_remove": "servers.0, servers.2, ports.1, ports.5",
+			},
+			expected: map[string]interface{}{
+				"servers": []interface{}{
+					map[string]interface{}{"host": "b"},
+				},
+				"ports": []interface{}{80, 8080},
+			},
+		},
+		{
+			name: "all array elements",
+			oldMap: map[string]interface{}{
+				"servers": []interface{}{"a", "b"},
+			},
+			newMap: map[string]interface{}{
+				"servers":                   []interface{}{},
+				"cleanup_deprecated_remove": "servers.*",
+			},
+			expected: map[string]interface{}{
+				"servers": []interface{}{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertMerged(t, tt.newMap, tt.oldMap, tt.expected)
+		})
+	}
+}
+
+// TestMergeByKey checks key_merge_key: array elements are matched by an identity field instead of by position.
+func TestMergeByKey(t *testing.T) {
+	oldMap := map[string]interface{}{
+		"upstreams": []interface{}{
+			map[string]interface{}{"name": "api", "weight": float64(5), "host": "api.internal"},
+			map[string]interface{}{"name": "web", "weight": float64(3), "host": "web.internal"},
+			map[string]interface{}{"name": "custom", "weight": float64(1), "host": "added.by.operator"},
+		},
+	}
+
+	t.Run("reordered and inserted elements keep their old values", func(t *testing.T) {
+		assertMerged(t,
+			map[string]interface{}{
+				"upstreams": []interface{}{
+					map[string]interface{}{"name": "auth", "weight": float64(1), "host": "auth.internal"},
+					map[string]interface{}{"name": "web", "weight": float64(1), "host": "", "tls": false},
+					map[string]interface{}{"name": "api", "weight": float64(1), "host": ""},
+				},
+				"upstreams_merge_key": "name",
+			},
+			oldMap,
+			map[string]interface{}{
+				"upstreams": []interface{}{
+					map[string]interface{}{"host": "auth.internal", "name": "auth", "weight": float64(1)},
+					map[string]interface{}{"host": "web.internal", "name": "web", "tls": false, "weight": float64(3)},
+					map[string]interface{}{"host": "api.internal", "name": "api", "weight": float64(5)},
+					map[string]interface{}{"host": "added.by.operator", "name": "custom", "weight": float64(1)},
+				},
+			},
+		)
+	})
+
+	t.Run("drop removes old elements missing from new", func(t *testing.T) {
+		assertMerged(t,
+			map[string]interface{}{
+				"upstreams": []interface{}{
+					map[string]interface{}{"name": "web", "weight": float64(1), "host": ""},
+				},
+				"upstreams_merge_key": "name, drop",
+			},
+			oldMap,
+			map[string]interface{}{
+				"upstreams": []interface{}{
+					map[string]interface{}{"host": "web.internal", "name": "web", "weight": float64(3)},
+				},
+			},
+		)
+	})
+
+	t.Run("nested array and missing old array", func(t *testing.T) {
+		assertMerged(t,
+			map[string]interface{}{
+				"rabbit": map[string]interface{}{
+					"queues":           []interface{}{map[string]interface{}{"name": "jobs", "durable": true}},
+					"queues_merge_key": "name",
+				},
+			},
+			map[string]interface{}{},
+			map[string]interface{}{
+				"rabbit": map[string]interface{}{
+					"queues": []interface{}{map[string]interface{}{"durable": true, "name": "jobs"}},
+				},
+			},
+		)
+	})
+}
+
+// TestMergeArrayModes checks key_merge_mode and the default Options.ArrayMode.
+func TestMergeArrayModes(t *testing.T) {
+	oldMap := map[string]interface{}{
+		"allow": []interface{}{"10.0.0.1", "10.0.0.9", "10.0.0.9"},
+	}
+	newArr := []interface{}{"127.0.0.1", "10.0.0.1"}
+
+	tests := []struct {
+		mode     ArrayMode
+		expected []interface{}
+	}{
+		{ArrayMerge, []interface{}{"10.0.0.1", "10.0.0.9", "10.0.0.9"}},
+		{ArrayReplace, []interface{}{"127.0.0.1", "10.0.0.1"}},
+		{ArrayAppend, []interface{}{"10.0.0.1", "10.0.0.9", "10.0.0.9", "127.0.0.1"}},
+		{ArrayUnion, []interface{}{"10.0.0.1", "10.0.0.9", "127.0.0.1"}},
+		{ArrayKeep, []interface{}{"10.0.0.1", "10.0.0.9", "10.0.0.9"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			assertMerged(t,
+				map[string]interface{}{"allow": newArr, "allow_merge_mode": string(tt.mode)},
+				oldMap,
+				map[string]interface{}{"allow": tt.expected},
+			)
+
+			result := MergeWithOptions(map[string]interface{}{"allow": newArr}, oldMap, Options{ArrayMode: tt.mode})
+			res, _ := json.Marshal(result)
+			exp, _ := json.Marshal(map[string]interface{}{"allow": tt.expected})
+			if string(res) != string(exp) {
+				t.Errorf("Default mode %s:\n got    %s\n expect %s", tt.mode, res, exp)
+			}
+		})
+	}
+
+	t.Run("directive overrides default and nested arrays use default", func(t *testing.T) {
+		result := MergeWithOptions(
+			map[string]interface{}{
+				"allow":            newArr,
+				"allow_merge_mode": "keep",
+				"users": []interface{}{
+					map[string]interface{}{"name": "", "roles": []interface{}{"read"}},
+				},
+			},
+			map[string]interface{}{
+				"allow": []interface{}{"10.0.0.9"},
+				"users": []interface{}{
+					map[string]interface{}{"name": "ops", "roles": []interface{}{"admin"}},
+				},
+			},
+			Options{ArrayMode: ArrayUnion},
+		)
+		res, _ := json.Marshal(result)
+		exp := `{"allow":["10.0.0.9"],"users":[{"name":"ops","roles":["admin"]},{"name":"","roles":["read"]}]}`
+		if string(res) != exp {
+			t.Errorf("Merge result:\n got    %s\n expect %s", res, exp)
+		}
+	})
+
+	if _, err := ParseArrayMode("sideways"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestMergeStrict(t *testing.T) {
+	newMap := map[string]interface{}{
+		"db": map[string]interface{}{
+			"dsn":            "",
+			"dsn_deprecated": "databse.url",
+		},
+		"hosts":                   []interface{}{map[string]interface{}{"name": ""}},
+		"hosts_deprecated_expand": "servers",
+		"allow":                   []interface{}{"127.0.0.1"},
+		"allow_merge_mode":        "sideways",
+		"port":                    8080,
+		"port_deprecated":         "http.port",
+	}
+	oldMap := map[string]interface{}{
+		"http":  map[string]interface{}{"port": 80},
+		"allow": []interface{}{"10.0.0.1"},
+	}
+
+	result, err := MergeWithErrors(newMap, oldMap, Options{Strict: true})
+	var errs DirectiveErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected DirectiveErrors, got %v", err)
+	}
+	expected := DirectiveErrors{
+		{Key: "allow_merge_mode", Path: "sideways", Reason: "unknown array merge mode"},
+		{Key: "db.dsn_deprecated", Path: "databse.url", Reason: "path not found in old config"},
+		{Key: "hosts_deprecated_expand", Path: "servers", Reason: `value must be "path->field"`},
+	}
+	if !reflect.DeepEqual(errs, expected) {
+		t.Errorf("Errors:\n got    %v\n expect %v", errs, expected)
+	}
+	if !strings.HasPrefix(err.Error(), "3 unresolved directives: allow_merge_mode: unknown array merge mode: sideways; ") {
+		t.Errorf("unexpected message %q", err)
+	}
+
+	// The directives that resolved are applied anyway
+	if result["port"] != 80 {
+		t.Errorf("expected port to be taken from http.port, got %v", result["port"])
+	}
+
+	// Without an old config, e.g. on the first migration, missing paths are expected
+	if _, err := MergeWithErrors(map[string]interface{}{"dsn": "", "dsn_deprecated": "database.url"}, map[string]interface{}{}, Options{Strict: true}); err != nil {
+		t.Errorf("expected no error without an old config, got %v", err)
+	}
+
+	// Not strict
+	if _, err := MergeWithErrors(newMap, oldMap, Options{}); err != nil {
+		t.Errorf("expected no error when not strict, got %v", err)
+	}
+}
+
+func TestMergeWithTrace(t *testing.T) {
+	newMap := map[string]interface{}{
+		"db": map[string]interface{}{
+			"url":            "",
+			"url_deprecated": "db.dsn",
+			"pool":           10,
+		},
+		"hosts":                     []interface{}{map[string]interface{}{"name": "", "port": 80}},
+		"hosts_deprecated_expand":   "servers->name",
+		"address":                   "",
+		"address_deprecated_concat": "db.url,db.pool->{0}?pool={1}",
+		"mode":                      "fast",
+		"mode_deprecated_replace":   "",
+		"allow":                     []interface{}{"127.0.0.1"},
+		"allow_merge_mode":          "union",
+		"name":                      "",
+	}
+	oldMap := map[string]interface{}{
+		"db":      map[string]interface{}{"dsn": "postgres://db/app"},
+		"servers": []interface{}{"a", "b"},
+		"mode":    "slow",
+		"allow":   []interface{}{"10.0.0.1"},
+		"name":    "app",
+	}
+
+	_, trace, err := MergeWithTrace(newMap, oldMap, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type entry struct {
+		source    Source
+		directive string
+		from      string
+	}
+	expected := map[string]entry{
+		"address":      {SourceConcat, "address_deprecated_concat", "db.url,db.pool->{0}?pool={1}"},
+		"allow":        {SourceMerged, "", ""},
+		"db.pool":      {SourceNew, "", ""},
+		"db.url":       {SourceDeprecated, "db.url_deprecated", "db.dsn"},
+		"hosts.0.name": {SourceExpand, "hosts_deprecated_expand", "servers->name"},
+		"hosts.0.port": {SourceExpand, "hosts_deprecated_expand", "servers->name"},
+		"hosts.1.name": {SourceExpand, "hosts_deprecated_expand", "servers->name"},
+		"hosts.1.port": {SourceExpand, "hosts_deprecated_expand", "servers->name"},
+		"mode":         {SourceReplace, "mode_deprecated_replace", ""},
+		"name":         {SourceOld, "", ""},
+	}
+
+	got := map[string]entry{}
+	var paths []string
+	for _, e := range trace {
+		got[e.Path] = entry{e.Source, e.Directive, e.From}
+		paths = append(paths, e.Path)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Trace:\n got    %v\n expect %v", got, expected)
+	}
+	if !sort.StringsAreSorted(paths) {
+		t.Errorf("expected trace sorted by path, got %v", paths)
+	}
+	if trace[0].Value != "postgres://db/app?pool=10" {
+		t.Errorf("unexpected value of %s: %v", trace[0].Path, trace[0].Value)
+	}
+}
+
+func TestRegisterDirective(t *testing.T) {
+	// key_split: "path" — the comma-separated string at path of the old config as an array
+	RegisterDirective("_split", PhaseDeprecated+1, func(ctx *DirectiveContext) error {
+		path, _ := ctx.Value.(string)
+		v, ok := ctx.OldValue(path)
+		if !ok {
+			return fmt.Errorf("path not found in old config")
+		}
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s is not a string", path)
+		}
+		ctx.Set(strings.Split(s, ","))
+		return nil
+	})
+
+	newMap := map[string]interface{}{
+		"http": map[string]interface{}{
+			"hosts":         []interface{}{},
+			"hosts_split":   "hosts",
+			"origins":       []interface{}{},
+			"origins_split": "cors",
+		},
+	}
+	oldMap := map[string]interface{}{"hosts": "a,b"}
+
+	assertMerged(t, newMap, oldMap, map[string]interface{}{
+		"http": map[string]interface{}{"hosts": []interface{}{"a", "b"}, "origins": []interface{}{}},
+	})
+
+	_, trace, err := MergeWithTrace(newMap, oldMap, Options{Strict: true})
+	expected := DirectiveErrors{{Key: "http.origins_split", Path: "cors", Reason: "path not found in old config"}}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("Errors:\n got    %v\n expect %v", err, expected)
+	}
+	if len(trace) != 2 || trace[0].Source != SourceDirective || trace[0].Directive != "http.hosts_split" || trace[0].From != "hosts" {
+		t.Errorf("unexpected trace %v", trace)
+	}
+
+	for _, suffix := range []string{"_split", "_deprecated", "_expand"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected registering %s to panic", suffix)
+				}
+			}()
+			RegisterDirective(suffix, PhaseConcat, func(*DirectiveContext) error { return nil })
+		}()
+	}
+}
+
+func TestMergeCast(t *testing.T) {
+	tests := []struct {
+		name     string
+		new      map[string]interface{}
+		old      map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:     "string to int",
+			new:      map[string]interface{}{"port": 80, "port_deprecated_cast": ""},
+			old:      map[string]interface{}{"port": "8080"},
+			expected: map[string]interface{}{"port": 8080},
+		},
+		{
+			name:     "int to string",
+			new:      map[string]interface{}{"port": "80", "port_deprecated_cast": ""},
+			old:      map[string]interface{}{"port": 8080},
+			expected: map[string]interface{}{"port": "8080"},
+		},
+		{
+			name:     "string to float",
+			new:      map[string]interface{}{"ratio": 0.5, "ratio_deprecated_cast": ""},
+			old:      map[string]interface{}{"ratio": "0.25"},
+			expected: map[string]interface{}{"ratio": 0.25},
+		},
+		{
+			name:     "string to bool",
+			new:      map[string]interface{}{"http": map[string]interface{}{"tls": false, "tls_deprecated_cast": ""}},
+			old:      map[string]interface{}{"http": map[string]interface{}{"tls": "yes"}},
+			expected: map[string]interface{}{"http": map[string]interface{}{"tls": true}},
+		},
+		{
+			name:     "seconds to duration",
+			new:      map[string]interface{}{"timeout": "30s", "timeout_deprecated_cast": "duration"},
+			old:      map[string]interface{}{"timeout": 90},
+			expected: map[string]interface{}{"timeout": "1m30s"},
+		},
+		{
+			name:     "duration is kept",
+			new:      map[string]interface{}{"timeout": "30s", "timeout_deprecated_cast": "duration"},
+			old:      map[string]interface{}{"timeout": "2m"},
+			expected: map[string]interface{}{"timeout": "2m"},
+		},
+		{
+			name:     "scalar to array of one",
+			new:      map[string]interface{}{"ports": []interface{}{80}, "ports_deprecated_cast": ""},
+			old:      map[string]interface{}{"ports": "8080"},
+			expected: map[string]interface{}{"ports": []interface{}{8080}},
+		},
+		{
+			name:     "array of one to scalar",
+			new:      map[string]interface{}{"host": "localhost", "host_deprecated_cast": ""},
+			old:      map[string]interface{}{"host": []interface{}{"db"}},
+			expected: map[string]interface{}{"host": "db"},
+		},
+		{
+			name:     "value moved by deprecated",
+			new:      map[string]interface{}{"port": 80, "port_deprecated": "http.port", "port_deprecated_cast": ""},
+			old:      map[string]interface{}{"http": map[string]interface{}{"port": "8080"}},
+			expected: map[string]interface{}{"port": 8080},
+		},
+		{
+			name:     "failed conversion keeps default",
+			new:      map[string]interface{}{"port": 80, "port_deprecated_cast": ""},
+			old:      map[string]interface{}{"port": "http"},
+			expected: map[string]interface{}{"port": 80},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertMerged(t, tt.new, tt.old, tt.expected)
+		})
+	}
+
+	_, err := MergeWithErrors(
+		map[string]interface{}{
+			"port": 80, "port_deprecated_cast": "", "tls": false, "tls_deprecated_cast": "boolean",
+			"workers": 4, "workers_deprecated_cast": "",
+		},
+		map[string]interface{}{"port": "http", "tls": "on", "worker": "8"},
+		Options{Strict: true},
+	)
+	expected := DirectiveErrors{
+		{Key: "port_deprecated_cast", Path: "http", Reason: "cannot convert to int"},
+		{Key: "tls_deprecated_cast", Path: "boolean", Reason: "unknown type"},
+		{Key: "workers_deprecated_cast", Path: "workers", Reason: "path not found in old config"},
+	}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("Errors:\n got    %v\n expect %v", err, expected)
+	}
+}
+
+func TestMergeMap(t *testing.T) {
+	levels := map[string]interface{}{"development": "debug", "production": "info"}
+
+	tests := []struct {
+		name     string
+		new      map[string]interface{}
+		old      map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:     "old value is mapped",
+			new:      map[string]interface{}{"log": map[string]interface{}{"level": "info", "level_deprecated_map": levels}},
+			old:      map[string]interface{}{"log": map[string]interface{}{"level": "development"}},
+			expected: map[string]interface{}{"log": map[string]interface{}{"level": "debug"}},
+		},
+		{
+			name:     "value without mapping is kept",
+			new:      map[string]interface{}{"level": "info", "level_deprecated_map": levels},
+			old:      map[string]interface{}{"level": "warn"},
+			expected: map[string]interface{}{"level": "warn"},
+		},
+		{
+			name: "default",
+			new: map[string]interface{}{"level": "info", "level_deprecated_map": map[string]interface{}{
+				"development": "debug", "*": "info",
+			}},
+			old:      map[string]interface{}{"level": "verbose"},
+			expected: map[string]interface{}{"level": "info"},
+		},
+		{
+			name: "new default is not mapped",
+			new: map[string]interface{}{"mode": "development", "mode_deprecated_map": map[string]interface{}{
+				"development": "dev", "*": "prod",
+			}},
+			old:      map[string]interface{}{"port": 80},
+			expected: map[string]interface{}{"mode": "development"},
+		},
+		{
+			name: "non-string values and arrays",
+			new: map[string]interface{}{"flags": []interface{}{"on"}, "flags_deprecated_map": map[string]interface{}{
+				"true": "on", "false": "off",
+			}},
+			old:      map[string]interface{}{"flags": []interface{}{true, false, "auto"}},
+			expected: map[string]interface{}{"flags": []interface{}{"on", "off", "auto"}},
+		},
+		{
+			name: "non-string keys",
+			new: map[string]interface{}{"workers": 4, "workers_deprecated_map": map[interface{}]interface{}{
+				1: 2, 2: 4,
+			}},
+			old:      map[string]interface{}{"workers": 1},
+			expected: map[string]interface{}{"workers": 2},
+		},
+		{
+			name:     "value moved by deprecated",
+			new:      map[string]interface{}{"level": "info", "level_deprecated": "debug", "level_deprecated_map": levels},
+			old:      map[string]interface{}{"debug": "production"},
+			expected: map[string]interface{}{"level": "info"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assertMerged(t, tt.new, tt.old, tt.expected)
+		})
+	}
+
+	_, err := MergeWithErrors(
+		map[string]interface{}{"level": "info", "level_deprecated_map": "development->debug"},
+		map[string]interface{}{"level": "development"},
+		Options{Strict: true},
+	)
+	expected := DirectiveErrors{{Key: "level_deprecated_map", Path: "development->debug", Reason: "value must be a mapping of old to new values"}}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("Errors:\n got    %v\n expect %v", err, expected)
+	}
+}
+
 // assertMerged asserts that Merge(newMap, oldMap) produces expected (compared as JSON).
 func assertMerged(t *testing.T, newMap, oldMap, expected map[string]interface{}) {
 	t.Helper()
@@ -1344,7 +1965,7 @@ func BenchmarkMergeMapsFlat(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = mergeMaps(newMap, oldMap)
+		_ = mergeMaps(newMap, oldMap, ArrayMerge)
 	}
 }
 
@@ -1365,7 +1986,7 @@ func BenchmarkMergeMapsNested(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = mergeMaps(newMap, oldMap)
+		_ = mergeMaps(newMap, oldMap, ArrayMerge)
 	}
 }
 
@@ -1394,7 +2015,7 @@ func BenchmarkMergeMapsDeepNestedArray(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = mergeMaps(newMap, oldMap)
+		_ = mergeMaps(newMap, oldMap, ArrayMerge)
 	}
 }
 
@@ -1440,7 +2061,7 @@ func BenchmarkMergeMaps_DeepNestedLarge(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = mergeMaps(newMap, oldMap)
+		_ = mergeMaps(newMap, oldMap, ArrayMerge)
 	}
 }
 
@@ -1478,6 +2099,6 @@ func BenchmarkMergeMaps_Massive(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = mergeMaps(newMap, oldMap)
+		_ = mergeMaps(newMap, oldMap, ArrayMerge)
 	}
 }
@@ -0,0 +1,209 @@
+package merger
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Source describes where a value of the merged config came from.
+type Source string
+
+const (
+	// SourceOld — the value was kept from the old config.
+	SourceOld Source = "old"
+	// SourceNew — the value is the default of the new config.
+	SourceNew Source = "new"
+	// SourceMerged — an array combined from elements of the old and the new config.
+	SourceMerged Source = "merged"
+	// SourceDeprecated — the value was moved from another path of the old config by _deprecated.
+	SourceDeprecated Source = "deprecated"
+	// SourceCast — the old value was converted to another type by _deprecated_cast.
+	SourceCast Source = "cast"
+	// SourceMap — the old value was renamed by _deprecated_map.
+	SourceMap Source = "map"
+	// SourceExpand — the value was built by _deprecated_expand.
+	SourceExpand Source = "expand"
+	// SourceCollapse — the value was built by _deprecated_collapse.
+	SourceCollapse Source = "collapse"
+	// SourceConcat — the value was built by _deprecated_concat.
+	SourceConcat Source = "concat"
+	// SourceReplace — the value of the new config was forced by _deprecated_replace.
+	SourceReplace Source = "replace"
+	// SourceReplacer — the value was produced by a replacer.
+	SourceReplacer Source = "replacer"
+	// SourceDirective — the value was set by a directive registered with RegisterDirective.
+	SourceDirective Source = "directive"
+)
+
+// TraceEntry explains the value of one leaf key path of the merged config.
+type TraceEntry struct {
+	// Path — dot-separated key path; elements of arrays of objects are addressed by index, e.g. "users.0.name".
+	// Arrays of scalars and empty maps are leaves.
+	Path string
+
+	// Source — where the value came from.
+	Source Source
+
+	// Directive — dot-separated path of the directive key that produced the value, e.g. "db.url_deprecated".
+	// Empty unless the value was produced by a directive.
+	Directive string
+
+	// From — the value of the directive: the source path or spec. For SourceReplacer, the value before replacing.
+	From string
+
+	// Value — the value in the merged config.
+	Value interface{}
+}
+
+// Trace explains every leaf of a merged config, sorted by path.
+type Trace []TraceEntry
+
+// MergeWithTrace is MergeWithErrors that also returns a Trace of where every value of the merged config came from.
+func MergeWithTrace(new, old map[string]interface{}, opts Options) (map[string]interface{}, Trace, error) {
+	t := &tracer{}
+	m, err := merge(new, old, opts, t)
+	return m, t.trace(m, new, old), err
+}
+
+// tracer records the key paths written by directives. A nil tracer discards them.
+type tracer struct {
+	records []traceRecord
+	// beforeReplace is a copy of the merged config before the replacers ran.
+	beforeReplace map[string]interface{}
+}
+
+type traceRecord struct {
+	path      string
+	source    Source
+	directive string
+	from      string
+}
+
+// record notes that the value at path was written by the directive key below prefix. Later records win.
+func (t *tracer) record(path string, source Source, prefix, key string, from interface{}) {
+	if t == nil {
+		return
+	}
+	spec := ""
+	if from != nil {
+		spec = fmt.Sprint(from)
+	}
+	t.records = append(t.records, traceRecord{path: path, source: source, directive: joinKey(prefix, key), from: spec})
+}
+
+// trace explains every leaf of the merged config m of new and old.
+func (t *tracer) trace(m, new, old map[string]interface{}) Trace {
+	var out Trace
+	walkLeaves(m, "", func(path string, value interface{}) {
+		out = append(out, t.explain(path, value, new, old))
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+func (t *tracer) explain(path string, value interface{}, new, old map[string]interface{}) TraceEntry {
+	e := TraceEntry{Path: path, Value: value}
+
+	if t.beforeReplace != nil {
+		if before, ok := lookupLeaf(t.beforeReplace, path); ok && !reflect.DeepEqual(before, value) {
+			e.Source, e.From = SourceReplacer, fmt.Sprint(before)
+			return e
+		}
+	}
+
+	for i := len(t.records) - 1; i >= 0; i-- {
+		rec := t.records[i]
+		if rec.path == path || strings.HasPrefix(path, rec.path+".") {
+			e.Source, e.Directive, e.From = rec.source, rec.directive, rec.from
+			return e
+		}
+	}
+
+	oldVal, inOld := lookupLeaf(old, path)
+	newVal, inNew := lookupLeaf(new, path)
+	_, isArr := toSlice(value)
+	switch {
+	case inOld && reflect.DeepEqual(oldVal, value):
+		e.Source = SourceOld
+	case inNew && reflect.DeepEqual(newVal, value):
+		e.Source = SourceNew
+	case isArr && inOld && inNew:
+		e.Source = SourceMerged
+	case inOld || !inNew:
+		// Kept from old, e.g. an element moved by _merge_key
+		e.Source = SourceOld
+	default:
+		e.Source = SourceNew
+	}
+	return e
+}
+
+// walkLeaves calls fn for every leaf below v in key order.
+func walkLeaves(v interface{}, path string, fn func(path string, value interface{})) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if len(t) == 0 && path != "" {
+			fn(path, v)
+			return
+		}
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			walkLeaves(t[k], joinKey(path, k), fn)
+		}
+	case []map[string]interface{}:
+		for i, elem := range t {
+			walkLeaves(elem, joinKey(path, strconv.Itoa(i)), fn)
+		}
+	case []interface{}:
+		if !hasObject(t) {
+			fn(path, v)
+			return
+		}
+		for i, elem := range t {
+			walkLeaves(elem, joinKey(path, strconv.Itoa(i)), fn)
+		}
+	default:
+		fn(path, v)
+	}
+}
+
+func hasObject(arr []interface{}) bool {
+	for _, elem := range arr {
+		if _, ok := elem.(map[string]interface{}); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupLeaf returns the value at a path of walkLeaves below m.
+func lookupLeaf(m map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = m
+	for _, key := range strings.Split(path, ".") {
+		switch t := current.(type) {
+		case map[string]interface{}:
+			v, ok := t[key]
+			if !ok {
+				return nil, false
+			}
+			current = v
+		case []interface{}, []map[string]interface{}:
+			arr, _ := toSlice(t)
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(arr) {
+				return nil, false
+			}
+			current = arr[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,97 @@
+package merger
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Keys ending with _deprecated_map: value is a mapping from old to new values, e.g. {development: debug}.
+// The value of the target key kept from the old config is replaced by its mapping; the "*" entry, if given, is
+// the mapping of all other values. Elements of an array of scalars are mapped one by one.
+const deprecatedMapSuffix = "_deprecated_map"
+
+// mapDefaultKey is the entry of a _deprecated_map mapping used for values without an entry of their own.
+const mapDefaultKey = "*"
+
+// applyDeprecatedMapInto applies key_deprecated_map to m[key] if it was kept from the old config or moved there
+// by a directive. Values without a mapping and no "*" entry are left alone.
+func applyDeprecatedMapInto(m, new, old map[string]interface{}, r *reporter, t *tracer, prefix string) {
+	if m == nil || new == nil || old == nil {
+		return
+	}
+	for k, v := range new {
+		if !strings.HasSuffix(k, deprecatedMapSuffix) {
+			if newMap, ok := v.(map[string]interface{}); ok && !isDirectiveKey(k) {
+				if mChild, ok := m[k].(map[string]interface{}); ok {
+					applyDeprecatedMapInto(mChild, newMap, old, r, t, joinKey(prefix, k))
+				}
+			}
+			continue
+		}
+		delete(m, k)
+
+		mapping, ok := toMapping(v)
+		if !ok {
+			r.add(prefix, k, v, "value must be a mapping of old to new values")
+			continue
+		}
+		targetKey := strings.TrimSuffix(k, deprecatedMapSuffix)
+		cur, exists := m[targetKey]
+		if !exists {
+			continue
+		}
+		// The default of the new config is not an old value
+		if _, found := getValueByPath(old, joinKey(prefix, targetKey)); !found && reflect.DeepEqual(cur, new[targetKey]) {
+			continue
+		}
+
+		mapped, changed := mapValue(cur, mapping)
+		if changed {
+			m[targetKey] = mapped
+			t.record(joinKey(prefix, targetKey), SourceMap, prefix, k, nil)
+		}
+	}
+}
+
+// toMapping returns the _deprecated_map spec v keyed by the text of its keys, as values are looked up. YAML decodes a
+// mapping with keys such as true or 1 to map[interface{}]interface{}.
+func toMapping(v interface{}) (map[string]interface{}, bool) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		return t, true
+	case map[interface{}]interface{}:
+		mapping := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			mapping[fmt.Sprint(k)] = val
+		}
+		return mapping, true
+	}
+	return nil, false
+}
+
+// mapValue returns the mapping of the scalar v, or of every element of the array v, and whether anything was mapped.
+func mapValue(v interface{}, mapping map[string]interface{}) (interface{}, bool) {
+	if arr, ok := v.([]interface{}); ok {
+		out := make([]interface{}, len(arr))
+		changed := false
+		for i, elem := range arr {
+			mapped, ok := mapValue(elem, mapping)
+			out[i] = mapped
+			changed = changed || ok
+		}
+		return out, changed
+	}
+
+	switch v.(type) {
+	case nil, map[string]interface{}, []map[string]interface{}:
+		return v, false
+	}
+	if mapped, ok := mapping[fmt.Sprint(v)]; ok {
+		return mapped, true
+	}
+	if mapped, ok := mapping[mapDefaultKey]; ok {
+		return mapped, true
+	}
+	return v, false
+}
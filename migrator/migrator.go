@@ -0,0 +1,339 @@
+// Package migrator applies config migrations from an fs.FS without golang-migrate source drivers or the URL registry.
+// Migrations are merged by driver.Config exactly as when it is used through golang-migrate.
+package migrator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+
+	config "github.com/c2pc/config-migrate/driver"
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/pkg/errors"
+)
+
+// NilVersion is the version of a config no migration has been applied to.
+const NilVersion = database.NilVersion
+
+// ErrDirty is returned when the last migration failed and the config must be checked and fixed with Force.
+type ErrDirty struct {
+	Version int
+}
+
+func (e ErrDirty) Error() string {
+	return fmt.Sprintf("dirty config version %d, fix and force version", e.Version)
+}
+
+// Migration is a migration found in the migrations directory.
+type Migration struct {
+	// Version — the version the up migration leads to.
+	Version uint
+
+	// Name — the name of the migration, e.g. "config" for "1_config.up.yaml".
+	Name string
+
+	up, down string // File names of the up and down migrations; empty if missing
+}
+
+// Status is the state of a config.
+type Status struct {
+	// Version — the current version; NilVersion if no migration was applied.
+	Version int
+
+	// Dirty — whether the last migration failed.
+	Dirty bool
+
+	// Applied — the migrations up to Version, oldest first.
+	Applied []Migration
+
+	// Pending — the migrations after Version, oldest first.
+	Pending []Migration
+}
+
+// Migrator applies migrations from a directory of an fs.FS to a config file.
+type Migrator struct {
+	fsys       fs.FS
+	dir        string
+	driver     config.Driver
+	settings   config.Settings
+	config     database.Driver
+	migrations []Migration // Sorted by version
+}
+
+// New returns a Migrator applying the migrations in the directory dir of fsys to the config file of settings,
+// read and written with driver. Migration files are named like for golang-migrate: "1_config.up.yaml".
+//
+//	m, err := migrator.New(migrations, "migrations", &yaml.Yaml{}, driver.Settings{Path: "config.yaml"})
+//	err = m.Up(ctx)
+func New(fsys fs.FS, dir string, driver config.Driver, settings config.Settings) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[uint]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		parsed, err := source.Parse(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		mg, ok := byVersion[parsed.Version]
+		if !ok {
+			mg = &Migration{Version: parsed.Version, Name: parsed.Identifier}
+			byVersion[parsed.Version] = mg
+		}
+
+		file := &mg.up
+		if parsed.Direction == source.Down {
+			file = &mg.down
+		}
+		if *file != "" {
+			return nil, errors.Errorf("duplicate migration file: %s", entry.Name())
+		}
+		*file = entry.Name()
+	}
+
+	m := &Migrator{
+		fsys:     fsys,
+		dir:      dir,
+		driver:   driver,
+		settings: settings,
+		config:   config.New(driver, settings),
+	}
+	for _, mg := range byVersion {
+		m.migrations = append(m.migrations, *mg)
+	}
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+
+	return m, nil
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.migrate(ctx, m.config, func(current int) ([]step, error) {
+		return m.upSteps(current, -1)
+	})
+}
+
+// Down rolls back all applied migrations.
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.migrate(ctx, m.config, func(current int) ([]step, error) {
+		return m.downSteps(current, -1)
+	})
+}
+
+// Steps applies the next n migrations if n > 0 or rolls back the last -n migrations if n < 0.
+// It stops at the last or first migration.
+func (m *Migrator) Steps(ctx context.Context, n int) error {
+	return m.migrate(ctx, m.config, func(current int) ([]step, error) {
+		if n < 0 {
+			return m.downSteps(current, -n)
+		}
+		return m.upSteps(current, n)
+	})
+}
+
+// Goto migrates up or down to version.
+func (m *Migrator) Goto(ctx context.Context, version uint) error {
+	return m.migrate(ctx, m.config, func(current int) ([]step, error) {
+		if _, err := m.index(int(version)); err != nil {
+			return nil, err
+		}
+		if int(version) > current {
+			return m.upSteps(current, m.count(current, int(version)))
+		}
+		return m.downSteps(current, m.count(int(version), current))
+	})
+}
+
+// Force sets version and clears the dirty flag without applying any migration.
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := m.config.Lock(); err != nil {
+		return err
+	}
+	defer m.config.Unlock()
+
+	return m.config.SetVersion(version, false)
+}
+
+// Status returns the current version and the applied and pending migrations.
+func (m *Migrator) Status(ctx context.Context) (Status, error) {
+	if err := ctx.Err(); err != nil {
+		return Status{}, err
+	}
+
+	if err := m.config.Lock(); err != nil {
+		return Status{}, err
+	}
+	defer m.config.Unlock()
+
+	version, dirty, err := m.config.Version()
+	if err != nil {
+		return Status{}, err
+	}
+
+	status := Status{Version: version, Dirty: dirty}
+	for _, mg := range m.migrations {
+		if int(mg.Version) <= version {
+			status.Applied = append(status.Applied, mg)
+		} else {
+			status.Pending = append(status.Pending, mg)
+		}
+	}
+	return status, nil
+}
+
+// Plan applies all pending migrations in dry-run mode and returns the config after each of them
+// and the would-be final config. Nothing is written.
+func (m *Migrator) Plan(ctx context.Context) ([]config.PlanStep, []byte, error) {
+	settings := m.settings
+	settings.DryRun = true
+	dryRun := config.New(m.driver, settings)
+
+	err := m.migrate(ctx, dryRun, func(current int) ([]step, error) {
+		return m.upSteps(current, -1)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dryRun.(*config.Config).Plan()
+}
+
+// step is one migration to apply: the file to run and the version it leads to.
+type step struct {
+	file   string // Empty if the migration has no file in this direction
+	target int
+}
+
+// migrate locks the config, computes the steps from the current version and applies them.
+func (m *Migrator) migrate(ctx context.Context, c database.Driver, steps func(current int) ([]step, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := c.Lock(); err != nil {
+		return err
+	}
+	defer c.Unlock()
+
+	current, dirty, err := c.Version()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return ErrDirty{Version: current}
+	}
+
+	todo, err := steps(current)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range todo {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := c.SetVersion(s.target, true); err != nil {
+			return err
+		}
+
+		if s.file != "" {
+			body, err := fs.ReadFile(m.fsys, path.Join(m.dir, s.file))
+			if err != nil {
+				return err
+			}
+			if err := c.Run(bytes.NewReader(body)); err != nil {
+				return errors.Wrapf(err, "migration %s failed", s.file)
+			}
+		}
+
+		if err := c.SetVersion(s.target, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upSteps returns up to limit up migrations after current; all of them if limit < 0.
+func (m *Migrator) upSteps(current, limit int) ([]step, error) {
+	start := 0
+	if current != NilVersion {
+		i, err := m.index(current)
+		if err != nil {
+			return nil, err
+		}
+		start = i + 1
+	}
+
+	var steps []step
+	for _, mg := range m.migrations[start:] {
+		if limit >= 0 && len(steps) == limit {
+			break
+		}
+		steps = append(steps, step{file: mg.up, target: int(mg.Version)})
+	}
+	return steps, nil
+}
+
+// downSteps returns up to limit down migrations from current; all of them if limit < 0.
+func (m *Migrator) downSteps(current, limit int) ([]step, error) {
+	if current == NilVersion {
+		return nil, nil
+	}
+
+	i, err := m.index(current)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []step
+	for ; i >= 0; i-- {
+		if limit >= 0 && len(steps) == limit {
+			break
+		}
+		target := NilVersion
+		if i > 0 {
+			target = int(m.migrations[i-1].Version)
+		}
+		steps = append(steps, step{file: m.migrations[i].down, target: target})
+	}
+	return steps, nil
+}
+
+// index returns the index of the migration to version.
+func (m *Migrator) index(version int) (int, error) {
+	i := sort.Search(len(m.migrations), func(i int) bool {
+		return int(m.migrations[i].Version) >= version
+	})
+	if i == len(m.migrations) || int(m.migrations[i].Version) != version {
+		return 0, errors.Errorf("no migration found for version %d", version)
+	}
+	return i, nil
+}
+
+// count returns the number of migrations after from up to and including to.
+func (m *Migrator) count(from, to int) int {
+	n := 0
+	for _, mg := range m.migrations {
+		if int(mg.Version) > from && int(mg.Version) <= to {
+			n++
+		}
+	}
+	return n
+}
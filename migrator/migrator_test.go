@@ -0,0 +1,215 @@
+package migrator_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	config "github.com/c2pc/config-migrate/driver"
+	jsonDriver "github.com/c2pc/config-migrate/driver/json"
+	"github.com/c2pc/config-migrate/migrator"
+)
+
+var migrations = fstest.MapFS{
+	"migrations/1_config.up.json":   {Data: []byte(`{"port": 80}`)},
+	"migrations/1_config.down.json": {Data: []byte(`{}`)},
+	"migrations/2_config.up.json":   {Data: []byte(`{"port": 80, "host": "localhost"}`)},
+	"migrations/2_config.down.json": {Data: []byte(`{"port": 80}`)},
+	"migrations/3_timeout.up.json":  {Data: []byte(`{"port": 80, "host": "localhost", "timeout": 30}`)},
+	"migrations/README.md":          {Data: []byte(`not a migration`)},
+}
+
+func newMigrator(t *testing.T) (*migrator.Migrator, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	m, err := migrator.New(migrations, "migrations", &jsonDriver.Json{}, config.Settings{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, path
+}
+
+func readConfig(t *testing.T, path string) map[string]interface{} {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]interface{}{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return got
+}
+
+func assertVersion(t *testing.T, m *migrator.Migrator, version int) {
+	t.Helper()
+	status, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Version != version || status.Dirty {
+		t.Errorf("expected version=%d dirty=false, got %d %t", version, status.Version, status.Dirty)
+	}
+}
+
+func TestUpDown(t *testing.T) {
+	ctx := context.Background()
+	m, path := newMigrator(t)
+
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, 3)
+	got := readConfig(t, path)
+	if got["port"].(float64) != 80 || got["host"] != "localhost" || got["timeout"].(float64) != 30 {
+		t.Errorf("unexpected config %v", got)
+	}
+
+	// Nothing to do is not an error
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Down(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, migrator.NilVersion)
+}
+
+func TestStepsAndGoto(t *testing.T) {
+	ctx := context.Background()
+	m, path := newMigrator(t)
+
+	if err := m.Steps(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, 2)
+
+	if err := m.Steps(ctx, -1); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, 1)
+	if got := readConfig(t, path); got["host"] != nil {
+		t.Errorf("expected host to be removed, got %v", got)
+	}
+
+	if err := m.Goto(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, 3)
+
+	if err := m.Goto(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, 1)
+
+	if err := m.Goto(ctx, 7); err == nil {
+		t.Error("expected error for unknown version")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newMigrator(t)
+
+	if err := m.Steps(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Applied) != 1 || status.Applied[0].Version != 1 || status.Applied[0].Name != "config" {
+		t.Errorf("unexpected applied %+v", status.Applied)
+	}
+	if len(status.Pending) != 2 || status.Pending[1].Version != 3 || status.Pending[1].Name != "timeout" {
+		t.Errorf("unexpected pending %+v", status.Pending)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	ctx := context.Background()
+	m, path := newMigrator(t)
+
+	if err := m.Steps(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps, data, err := m.Plan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 2 || steps[0].Version != 2 || steps[1].Version != 3 {
+		t.Errorf("unexpected steps %+v", steps)
+	}
+	got := map[string]interface{}{}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["timeout"].(float64) != 30 || got["version"].(float64) != 3 {
+		t.Errorf("unexpected plan %v", got)
+	}
+
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(before) != string(after) {
+		t.Errorf("config changed by plan: %s", after)
+	}
+	assertVersion(t, m, 1)
+}
+
+func TestDirtyAndForce(t *testing.T) {
+	ctx := context.Background()
+	m, path := newMigrator(t)
+
+	if err := os.WriteFile(path, []byte(`{"version": 2, "force": true}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var dirty migrator.ErrDirty
+	if err := m.Up(ctx); !errors.As(err, &dirty) || dirty.Version != 2 {
+		t.Fatalf("expected ErrDirty at version 2, got %v", err)
+	}
+
+	if err := m.Force(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	assertVersion(t, m, 3)
+}
+
+func TestContextCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m, _ := newMigrator(t)
+
+	if err := m.Up(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestNew_duplicate(t *testing.T) {
+	fsys := fstest.MapFS{
+		"1_a.up.json": {Data: []byte(`{}`)},
+		"1_b.up.json": {Data: []byte(`{}`)},
+	}
+	if _, err := migrator.New(fsys, ".", &jsonDriver.Json{}, config.Settings{}); err == nil {
+		t.Error("expected error for duplicate migration")
+	}
+}